@@ -0,0 +1,156 @@
+package rubyobj
+
+import (
+	"sort"
+)
+
+// GenerationStat is the population of objects allocated during a range of GC
+// generations.
+type GenerationStat struct {
+	// First and Last are the generations covered by this bucket, inclusively.
+	First uint64
+	Last  uint64
+
+	Count   uint64
+	Memsize uint64
+	// Old counts the objects that have since been promoted to the old
+	// generation.
+	Old uint64
+}
+
+// YoungRetained groups, by class, the young objects that are referenced by
+// at least one old object.  Such references keep young objects alive across
+// minor GCs; when the old referrer is not write-barrier protected, the
+// referrer is rescanned at every minor GC.
+type YoungRetained struct {
+	Class   string
+	Count   uint64
+	Memsize uint64
+	// Unprotected counts the young objects held by at least one old object
+	// that isn't write-barrier protected.
+	Unprotected uint64
+	// Samples are the addresses of some of those young objects.
+	Samples []uint64
+}
+
+// AgeReport describes the age of the objects of a heap.
+type AgeReport struct {
+	// Generations are sorted from the oldest to the youngest.
+	Generations []GenerationStat
+	// Untraced counts the objects with no generation information.
+	Untraced uint64
+
+	// YoungRetained is sorted by decreasing memsize.
+	YoungRetained []YoungRetained
+}
+
+// traced tells if the allocation of rObj was traced, in which case its
+// generation is known, even if it's 0.
+func traced(rObj *RubyObject) bool {
+	return rObj.Generation != 0 || rObj.File != ""
+}
+
+// maxAgeSamples is how many addresses are kept in YoungRetained.Samples.
+const maxAgeSamples = 5
+
+// NewAgeReport buckets the objects of h by the generation they were allocated
+// in, bucketSize generations per bucket, and finds the young objects that are
+// retained by old ones.
+func NewAgeReport(h *Heap, bucketSize uint64) *AgeReport {
+	if bucketSize == 0 {
+		bucketSize = 1
+	}
+
+	report := &AgeReport{}
+	buckets := make(map[uint64]*GenerationStat)
+	young := make(map[string]*YoungRetained)
+
+	objs := h.Objects()
+	for i := range objs {
+		rObj := &objs[i]
+
+		if !traced(rObj) {
+			report.Untraced++
+		} else {
+			first := rObj.Generation - rObj.Generation%bucketSize
+			stat, ok := buckets[first]
+			if !ok {
+				stat = &GenerationStat{First: first, Last: first + bucketSize - 1}
+				buckets[first] = stat
+			}
+			stat.Count++
+			stat.Memsize += rObj.Memsize
+			if rObj.GcOld() {
+				stat.Old++
+			}
+		}
+
+		if rObj.GcOld() {
+			continue
+		}
+		heldByOld, unprotected := oldReferrers(h, rObj.Address)
+		if !heldByOld {
+			continue
+		}
+		class := h.ClassName(rObj)
+		yr, ok := young[class]
+		if !ok {
+			yr = &YoungRetained{Class: class}
+			young[class] = yr
+		}
+		yr.Count++
+		yr.Memsize += rObj.Memsize
+		if unprotected {
+			yr.Unprotected++
+		}
+		if len(yr.Samples) < maxAgeSamples {
+			yr.Samples = append(yr.Samples, rObj.Address)
+		}
+	}
+
+	for _, stat := range buckets {
+		report.Generations = append(report.Generations, *stat)
+	}
+	sort.Sort(byFirstGeneration(report.Generations))
+
+	for _, yr := range young {
+		report.YoungRetained = append(report.YoungRetained, *yr)
+	}
+	sort.Sort(youngByMemsize(report.YoungRetained))
+
+	return report
+}
+
+// oldReferrers tells if addr is referenced by an old object, and if any of
+// those is not write-barrier protected.
+func oldReferrers(h *Heap, addr uint64) (heldByOld, unprotected bool) {
+	for _, from := range h.Referrers(addr) {
+		parent, ok := h.Lookup(from)
+		if !ok || !parent.GcOld() {
+			continue
+		}
+		heldByOld = true
+		if !parent.GcWbProtected() {
+			unprotected = true
+			return
+		}
+	}
+	return
+}
+
+type byFirstGeneration []GenerationStat
+
+func (b byFirstGeneration) Len() int           { return len(b) }
+func (b byFirstGeneration) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byFirstGeneration) Less(i, j int) bool { return b[i].First < b[j].First }
+
+type youngByMemsize []YoungRetained
+
+func (y youngByMemsize) Len() int      { return len(y) }
+func (y youngByMemsize) Swap(i, j int) { y[i], y[j] = y[j], y[i] }
+func (y youngByMemsize) Less(i, j int) bool {
+	if y[i].Memsize != y[j].Memsize {
+		return y[i].Memsize > y[j].Memsize
+	}
+	return y[i].Class < y[j].Class
+}
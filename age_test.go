@@ -0,0 +1,108 @@
+package rubyobj_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aybabtme/rubyobj"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAgeReportGenerations(t *testing.T) {
+	h := loadHeap(t, `{"address":"0x7fc96c000100", "type":"CLASS", "name":"Foo"}
+{"address":"0x7fc96c000001", "type":"OBJECT", "file":"a.rb", "line":1, "generation":0, "memsize":1}
+{"address":"0x7fc96c000002", "type":"OBJECT", "file":"a.rb", "line":1, "generation":9, "memsize":2}
+{"address":"0x7fc96c000003", "type":"OBJECT", "file":"a.rb", "line":1, "generation":10, "memsize":4}
+{"address":"0x7fc96c000004", "type":"OBJECT", "file":"a.rb", "line":1, "generation":25, "memsize":8, "flags":{"old":true}}
+{"address":"0x7fc96c000005", "type":"OBJECT", "file":"a.rb", "line":1, "generation":29, "memsize":16}
+{"address":"0x7fc96c000006", "type":"OBJECT", "memsize":32}
+`)
+
+	tests := []struct {
+		bucketSize uint64
+		want       []rubyobj.GenerationStat
+	}{
+		{10, []rubyobj.GenerationStat{
+			{First: 0, Last: 9, Count: 2, Memsize: 3},
+			{First: 10, Last: 19, Count: 1, Memsize: 4},
+			{First: 20, Last: 29, Count: 2, Memsize: 24, Old: 1},
+		}},
+		{20, []rubyobj.GenerationStat{
+			{First: 0, Last: 19, Count: 3, Memsize: 7},
+			{First: 20, Last: 39, Count: 2, Memsize: 24, Old: 1},
+		}},
+		// A bucket size of 0 is one generation per bucket.
+		{0, []rubyobj.GenerationStat{
+			{First: 0, Last: 0, Count: 1, Memsize: 1},
+			{First: 9, Last: 9, Count: 1, Memsize: 2},
+			{First: 10, Last: 10, Count: 1, Memsize: 4},
+			{First: 25, Last: 25, Count: 1, Memsize: 8, Old: 1},
+			{First: 29, Last: 29, Count: 1, Memsize: 16},
+		}},
+	}
+	for _, tt := range tests {
+		report := rubyobj.NewAgeReport(h, tt.bucketSize)
+		if !reflect.DeepEqual(report.Generations, tt.want) {
+			t.Errorf("buckets of %d: want %+v, got %+v", tt.bucketSize, tt.want, report.Generations)
+		}
+		// The class and the object without a file or a generation.
+		if report.Untraced != 2 {
+			t.Errorf("buckets of %d: want 2 untraced objects, got %d", tt.bucketSize, report.Untraced)
+		}
+	}
+}
+
+func TestAgeReportYoungRetained(t *testing.T) {
+	const (
+		protected   = "0x7fc96c0000a1"
+		unprotected = "0x7fc96c0000a2"
+		young       = "0x7fc96c0000a3"
+	)
+	dump := bytes.NewBufferString(`{"address":"0x7fc96c000100", "type":"CLASS", "name":"Foo"}
+{"address":"0x7fc96c000200", "type":"CLASS", "name":"Bar"}
+{"address":"0x7fc96c000300", "type":"CLASS", "name":"Baz"}
+`)
+	// 7 young Foos held by the protected old object, more than are sampled.
+	var foos []string
+	for i := 0; i < 7; i++ {
+		addr := fmt.Sprintf("0x7fc96c0001%02x", i+1)
+		foos = append(foos, addr)
+		fmt.Fprintf(dump, `{"address":"%s", "type":"OBJECT", "class":"0x7fc96c000100", "memsize":10}`+"\n", addr)
+	}
+	// Bars held by the unprotected old object, by the protected one, and
+	// by both, the unprotected one last.
+	fmt.Fprintf(dump, `{"address":"0x7fc96c000201", "type":"OBJECT", "class":"0x7fc96c000200", "memsize":40}
+{"address":"0x7fc96c000202", "type":"OBJECT", "class":"0x7fc96c000200", "memsize":40}
+{"address":"0x7fc96c000203", "type":"OBJECT", "class":"0x7fc96c000200", "memsize":40}
+{"address":"0x7fc96c000301", "type":"OBJECT", "class":"0x7fc96c000300", "memsize":1000}
+{"address":"0x7fc96c000302", "type":"OBJECT", "class":"0x7fc96c000300", "memsize":1000, "flags":{"old":true, "wb_protected":true}}
+{"address":"%s", "type":"OBJECT", "references":["%s", "0x7fc96c000202", "0x7fc96c000203", "0x7fc96c000302"], "flags":{"old":true, "wb_protected":true}}
+{"address":"%s", "type":"OBJECT", "references":["0x7fc96c000201", "0x7fc96c000203"], "flags":{"old":true}}
+{"address":"%s", "type":"OBJECT", "references":["0x7fc96c000301"], "flags":{"wb_protected":true}}
+`, protected, strings.Join(foos, `", "`), unprotected, young)
+	h := loadHeap(t, dump.String())
+
+	report := rubyobj.NewAgeReport(h, 1)
+	// The Baz held by a young object isn't retained, and the one held by
+	// an old object is old itself.
+	if len(report.YoungRetained) != 2 {
+		t.Fatalf("want the Bars and the Foos, got %+v", report.YoungRetained)
+	}
+	bars, foosRetained := report.YoungRetained[0], report.YoungRetained[1]
+	if bars.Class != "Bar" || bars.Count != 3 || bars.Memsize != 120 || bars.Unprotected != 2 {
+		t.Errorf("want 3 Bars, 2 held by the unprotected object, got %+v", bars)
+	}
+	if foosRetained.Class != "Foo" || foosRetained.Count != 7 || foosRetained.Memsize != 70 || foosRetained.Unprotected != 0 {
+		t.Errorf("want 7 protected Foos, got %+v", foosRetained)
+	}
+	if len(foosRetained.Samples) != 5 {
+		t.Fatalf("want 5 samples, got %d", len(foosRetained.Samples))
+	}
+	for i, addr := range foosRetained.Samples {
+		if rubyobj.FormatAddress(addr) != foos[i] {
+			t.Errorf("want the first Foos sampled, got %x", foosRetained.Samples)
+			break
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package rubyobj
+
+import (
+	"fmt"
+	"sort"
+)
+
+// AllocSite is a location in Ruby source where objects were allocated, as
+// recorded by ObjectSpace.trace_object_allocations.  Method and Class are only
+// set when the report groups by them.
+type AllocSite struct {
+	File   string
+	Line   uint64
+	Method string
+	Class  string
+}
+
+func (a AllocSite) String() string {
+	if a.File == "" {
+		return "(untraced)"
+	}
+	s := fmt.Sprintf("%s:%d", a.File, a.Line)
+	if a.Method != "" {
+		s += " in " + a.Method
+	}
+	if a.Class != "" {
+		s += " (" + a.Class + ")"
+	}
+	return s
+}
+
+// AllocSiteStat is the number of objects and memory allocated at an AllocSite.
+type AllocSiteStat struct {
+	AllocSite
+	Count   uint64
+	Memsize uint64
+}
+
+// AllocSiteOptions controls how an AllocSiteReport groups and filters objects.
+type AllocSiteOptions struct {
+	// ByMethod and ByClass split a file:line further by method and by the
+	// resolved class name of the objects.
+	ByMethod bool
+	ByClass  bool
+
+	// MinGeneration and MaxGeneration keep only objects allocated during
+	// that range of GC generations, inclusively.  A MaxGeneration of 0 means
+	// there is no upper bound.
+	MinGeneration uint64
+	MaxGeneration uint64
+
+	// Where, if set, ignores the objects for which it's false.
+	Where func(*RubyObject) bool
+}
+
+type allocSiteKey struct {
+	file   string
+	line   uint64
+	method string
+	class  uint64
+}
+
+// AllocSiteReport aggregates objects by their allocation site.  Objects are
+// fed one by one with Add, so it can be used directly on the output of
+// ParallelDecode.
+type AllocSiteReport struct {
+	opts    AllocSiteOptions
+	sites   map[allocSiteKey]*AllocSiteStat
+	classes classNames
+
+	// Untraced counts the objects that carried no allocation information.
+	Untraced uint64
+}
+
+// NewAllocSiteReport creates an empty report using opts.
+func NewAllocSiteReport(opts AllocSiteOptions) *AllocSiteReport {
+	return &AllocSiteReport{
+		opts:    opts,
+		sites:   make(map[allocSiteKey]*AllocSiteStat),
+		classes: make(classNames),
+	}
+}
+
+// Add accounts for rObj in the report.
+func (r *AllocSiteReport) Add(rObj *RubyObject) {
+	r.classes.observe(rObj)
+
+	if rObj.File == "" {
+		r.Untraced++
+		return
+	}
+	if rObj.Generation < r.opts.MinGeneration {
+		return
+	}
+	if r.opts.MaxGeneration != 0 && rObj.Generation > r.opts.MaxGeneration {
+		return
+	}
+	if r.opts.Where != nil && !r.opts.Where(rObj) {
+		return
+	}
+
+	key := allocSiteKey{file: rObj.File, line: rObj.Line}
+	if r.opts.ByMethod {
+		key.method = rObj.Method
+	}
+	if r.opts.ByClass {
+		key.class = rObj.Class
+	}
+
+	stat, ok := r.sites[key]
+	if !ok {
+		stat = &AllocSiteStat{AllocSite: AllocSite{
+			File:   key.file,
+			Line:   key.line,
+			Method: key.method,
+		}}
+		r.sites[key] = stat
+	}
+	stat.Count++
+	stat.Memsize += rObj.Memsize
+}
+
+// Sites returns the allocation sites seen so far, the ones holding the most
+// memory first.
+func (r *AllocSiteReport) Sites() []AllocSiteStat {
+	out := make([]AllocSiteStat, 0, len(r.sites))
+	for key, stat := range r.sites {
+		s := *stat
+		if r.opts.ByClass {
+			s.Class = r.classes.name(key.class)
+		}
+		out = append(out, s)
+	}
+	sort.Sort(byMemsize(out))
+	return out
+}
+
+type byMemsize []AllocSiteStat
+
+func (b byMemsize) Len() int      { return len(b) }
+func (b byMemsize) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byMemsize) Less(i, j int) bool {
+	if b[i].Memsize != b[j].Memsize {
+		return b[i].Memsize > b[j].Memsize
+	}
+	if b[i].Count != b[j].Count {
+		return b[i].Count > b[j].Count
+	}
+	return b[i].String() < b[j].String()
+}
+
+// classNames resolves class addresses to the name of the class, as found
+// in the CLASS and MODULE objects of a dump.
+type classNames map[uint64]string
+
+func (c classNames) observe(rObj *RubyObject) {
+	if (rObj.Type == Class || rObj.Type == Module) && rObj.Name != "" {
+		c[rObj.Address] = rObj.Name
+	}
+}
+
+// name returns the name of the class at addr, or its address when the class
+// is anonymous or absent from the dump.
+func (c classNames) name(addr uint64) string {
+	if name, ok := c[addr]; ok {
+		return name
+	}
+	return formatUint64(addr)
+}
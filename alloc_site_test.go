@@ -0,0 +1,95 @@
+package rubyobj_test
+
+import (
+	"fmt"
+	"github.com/aybabtme/rubyobj"
+	"strings"
+	"testing"
+)
+
+const allocSiteDump = `{"address":"0x7fc96c000100", "type":"CLASS", "name":"Foo"}
+{"address":"0x7fc96c000200", "type":"CLASS", "name":"Bar"}
+{"address":"0x7fc96c000001", "type":"OBJECT", "class":"0x7fc96c000100", "file":"a.rb", "line":1, "method":"foo", "generation":1, "memsize":10}
+{"address":"0x7fc96c000002", "type":"OBJECT", "class":"0x7fc96c000200", "file":"a.rb", "line":1, "method":"foo", "generation":2, "memsize":20}
+{"address":"0x7fc96c000003", "type":"OBJECT", "class":"0x7fc96c000100", "file":"a.rb", "line":1, "method":"bar", "generation":3, "memsize":30}
+{"address":"0x7fc96c000004", "type":"OBJECT", "class":"0x7fc96c000100", "file":"b.rb", "line":5, "method":"baz", "generation":4, "memsize":100}
+{"address":"0x7fc96c000005", "type":"OBJECT", "class":"0x7fc96c000200", "file":"c.rb", "line":2, "generation":5, "memsize":30}
+{"address":"0x7fc96c000006", "type":"OBJECT", "class":"0x7fc96c000200", "file":"c.rb", "line":2, "generation":5, "memsize":30}
+{"address":"0x7fc96c000007", "type":"OBJECT", "class":"0x7fc96c000300", "file":"e.rb", "line":1, "generation":6, "memsize":1}
+{"address":"0x7fc96c000008", "type":"OBJECT", "class":"0x7fc96c000300", "file":"d.rb", "line":1, "generation":6, "memsize":1}
+{"address":"0x7fc96c000009", "type":"STRING", "value":"abc", "memsize":50}
+`
+
+func TestAllocSiteReport(t *testing.T) {
+	objs := decodeDump(t, strings.NewReader(allocSiteDump))
+
+	tests := []struct {
+		name string
+		opts rubyobj.AllocSiteOptions
+		want []string // site, count and memsize, in order
+	}{
+		{"file and line", rubyobj.AllocSiteOptions{}, []string{
+			"b.rb:5 1 100",
+			"a.rb:1 3 60", // more objects than c.rb:2 for the same memsize
+			"c.rb:2 2 60",
+			"d.rb:1 1 1", // then by name
+			"e.rb:1 1 1",
+		}},
+		{"by method", rubyobj.AllocSiteOptions{ByMethod: true}, []string{
+			"b.rb:5 in baz 1 100",
+			"c.rb:2 2 60",
+			"a.rb:1 in foo 2 30",
+			"a.rb:1 in bar 1 30",
+			"d.rb:1 1 1",
+			"e.rb:1 1 1",
+		}},
+		{"by class", rubyobj.AllocSiteOptions{ByClass: true}, []string{
+			"b.rb:5 (Foo) 1 100",
+			"c.rb:2 (Bar) 2 60",
+			"a.rb:1 (Foo) 2 40",
+			"a.rb:1 (Bar) 1 20",
+			"d.rb:1 (0x7fc96c000300) 1 1",
+			"e.rb:1 (0x7fc96c000300) 1 1",
+		}},
+		{"by method and class", rubyobj.AllocSiteOptions{ByMethod: true, ByClass: true}, []string{
+			"b.rb:5 in baz (Foo) 1 100",
+			"c.rb:2 (Bar) 2 60",
+			"a.rb:1 in bar (Foo) 1 30",
+			"a.rb:1 in foo (Bar) 1 20",
+			"a.rb:1 in foo (Foo) 1 10",
+			"d.rb:1 (0x7fc96c000300) 1 1",
+			"e.rb:1 (0x7fc96c000300) 1 1",
+		}},
+		{"generations", rubyobj.AllocSiteOptions{MinGeneration: 2, MaxGeneration: 4}, []string{
+			"b.rb:5 1 100",
+			"a.rb:1 2 50",
+		}},
+		{"from a generation", rubyobj.AllocSiteOptions{MinGeneration: 5}, []string{
+			"c.rb:2 2 60",
+			"d.rb:1 1 1",
+			"e.rb:1 1 1",
+		}},
+		{"where", rubyobj.AllocSiteOptions{Where: func(rObj *rubyobj.RubyObject) bool { return rObj.Memsize >= 30 }}, []string{
+			"b.rb:5 1 100",
+			"c.rb:2 2 60",
+			"a.rb:1 1 30",
+		}},
+	}
+	for _, tt := range tests {
+		report := rubyobj.NewAllocSiteReport(tt.opts)
+		for i := range objs {
+			report.Add(&objs[i])
+		}
+		var got []string
+		for _, s := range report.Sites() {
+			got = append(got, fmt.Sprintf("%s %d %d", s, s.Count, s.Memsize))
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: want sites\n%s\ngot\n%s", tt.name, strings.Join(tt.want, "\n"), strings.Join(got, "\n"))
+		}
+		// The classes and the string weren't traced, whatever the options.
+		if report.Untraced != 3 {
+			t.Errorf("%s: want 3 untraced objects, got %d", tt.name, report.Untraced)
+		}
+	}
+}
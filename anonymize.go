@@ -0,0 +1,94 @@
+package rubyobj
+
+import (
+	"strconv"
+	"strings"
+)
+
+// FileRule rewrites the files starting with Prefix to start with Replace
+// instead, like "/home/deploy/app/" to "app/".
+type FileRule struct {
+	Prefix  string
+	Replace string
+}
+
+// anonBase and anonStride place remapped addresses like slots of a Ruby heap,
+// while keeping them within the 12 hex digits of dump addresses.
+const (
+	anonBase   = 0x100000000000
+	anonStride = 40
+)
+
+// Anonymizer remaps the addresses of the objects of a dump, rewrites their
+// files and scrubs their names.  Addresses are remapped consistently across
+// Address, Class, References and Default, so the graph of an anonymized dump
+// is the same as the original's.
+//
+// Addresses are given out in the order they're first seen, so an Anonymizer
+// must see all the objects of a dump, in order, and isn't safe for
+// concurrent use.  Members of the dump that RubyObject doesn't know about are
+// left alone.
+type Anonymizer struct {
+	// Files are tried in order, the first matching one is applied.  Files
+	// matching none are kept as is.
+	Files []FileRule
+	// ScrubNames replaces each part of the names of classes and modules with
+	// a placeholder, so that Foo::Bar becomes C1::C2 and Foo::Baz becomes
+	// C1::C3.
+	ScrubNames bool
+
+	addrs map[uint64]uint64
+	names map[string]string
+}
+
+// Anonymize anonymizes rObj.  It can be used as a Pipeline transform.
+func (a *Anonymizer) Anonymize(rObj *RubyObject) {
+	rObj.Address = a.address(rObj.Address)
+	rObj.Class = a.address(rObj.Class)
+	rObj.Default = a.address(rObj.Default)
+	for i, ref := range rObj.References {
+		rObj.References[i] = a.address(ref)
+	}
+
+	for _, rule := range a.Files {
+		if strings.HasPrefix(rObj.File, rule.Prefix) {
+			rObj.File = rule.Replace + rObj.File[len(rule.Prefix):]
+			break
+		}
+	}
+
+	if a.ScrubNames && rObj.Name != "" {
+		parts := strings.Split(rObj.Name, "::")
+		for i, part := range parts {
+			parts[i] = a.name(part)
+		}
+		rObj.Name = strings.Join(parts, "::")
+	}
+}
+
+func (a *Anonymizer) address(addr uint64) uint64 {
+	if addr == 0 {
+		return 0
+	}
+	if a.addrs == nil {
+		a.addrs = make(map[uint64]uint64)
+	}
+	anon, ok := a.addrs[addr]
+	if !ok {
+		anon = anonBase + uint64(len(a.addrs))*anonStride
+		a.addrs[addr] = anon
+	}
+	return anon
+}
+
+func (a *Anonymizer) name(part string) string {
+	if a.names == nil {
+		a.names = make(map[string]string)
+	}
+	anon, ok := a.names[part]
+	if !ok {
+		anon = "C" + strconv.Itoa(len(a.names)+1)
+		a.names[part] = anon
+	}
+	return anon
+}
@@ -0,0 +1,153 @@
+package rubyobj_test
+
+import (
+	"bytes"
+	"github.com/aybabtme/rubyobj"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestAnonymizerKeepsGraph(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/small.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	anon := &rubyobj.Anonymizer{
+		Files:      []rubyobj.FileRule{{Prefix: "/", Replace: "root/"}},
+		ScrubNames: true,
+	}
+	out := bytes.NewBuffer(nil)
+	if _, err := rubyobj.NewPipeline().Transform(anon.Anonymize).Run(bytes.NewReader(data), out, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	objs := decodeFile(t, "testdata/small.json")
+	anonObjs := decodeDump(t, bytes.NewReader(out.Bytes()))
+	if len(objs) != len(anonObjs) {
+		t.Fatalf("want %d objects, got %d", len(objs), len(anonObjs))
+	}
+
+	// The addresses are mapped one to one.
+	mapping := make(map[uint64]uint64)
+	mapped := make(map[uint64]uint64)
+	mapAddr := func(from, to uint64) {
+		if from == 0 || to == 0 {
+			if from != to {
+				t.Fatalf("%s was mapped to %s", rubyobj.FormatAddress(from), rubyobj.FormatAddress(to))
+			}
+			return
+		}
+		if was, ok := mapping[from]; ok && was != to {
+			t.Fatalf("%s was mapped to %s and %s", rubyobj.FormatAddress(from), rubyobj.FormatAddress(was), rubyobj.FormatAddress(to))
+		}
+		if was, ok := mapped[to]; ok && was != from {
+			t.Fatalf("%s and %s were both mapped to %s", rubyobj.FormatAddress(was), rubyobj.FormatAddress(from), rubyobj.FormatAddress(to))
+		}
+		mapping[from], mapped[to] = to, from
+	}
+	for i := range objs {
+		was, now := objs[i], anonObjs[i]
+		mapAddr(was.Address, now.Address)
+		mapAddr(was.Class, now.Class)
+		mapAddr(was.Default, now.Default)
+		if len(was.References) != len(now.References) {
+			t.Fatalf("%s had %d references, now %d", rubyobj.FormatAddress(was.Address), len(was.References), len(now.References))
+		}
+		for j := range was.References {
+			mapAddr(was.References[j], now.References[j])
+		}
+		if was.Address != 0 && now.Address == was.Address {
+			t.Errorf("%s wasn't remapped", rubyobj.FormatAddress(was.Address))
+		}
+		if was.File != "" && now.File != "root/"+strings.TrimPrefix(was.File, "/") {
+			t.Errorf("want %q rewritten, got %q", was.File, now.File)
+		}
+		if was.Memsize != now.Memsize || was.Type != now.Type || was.Bytesize != now.Bytesize {
+			t.Errorf("more than the addresses of %s changed", rubyobj.FormatAddress(was.Address))
+		}
+	}
+
+	// So the heaps have the same shape.
+	h, err := rubyobj.LoadHeap(bytes.NewReader(data), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonHeap, err := rubyobj.LoadHeap(bytes.NewReader(out.Bytes()), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dom, anonDom := rubyobj.NewDominatorTree(h), rubyobj.NewDominatorTree(anonHeap)
+	for _, rObj := range h.Objects() {
+		addr := mapping[rObj.Address]
+		if got, want := len(anonHeap.Referrers(addr)), len(h.Referrers(rObj.Address)); got != want {
+			t.Fatalf("%s had %d referrers, now %d", rubyobj.FormatAddress(rObj.Address), want, got)
+		}
+		for _, from := range h.Referrers(rObj.Address) {
+			if !containsAddr(anonHeap.Referrers(addr), mapping[from]) {
+				t.Fatalf("%s lost referrer %s", rubyobj.FormatAddress(rObj.Address), rubyobj.FormatAddress(from))
+			}
+		}
+		if got, want := anonDom.RetainedSize(addr), dom.RetainedSize(rObj.Address); got != want {
+			t.Fatalf("%s retained %d bytes, now %d", rubyobj.FormatAddress(rObj.Address), want, got)
+		}
+	}
+	if got, want := len(rubyobj.StronglyConnected(anonHeap)), len(rubyobj.StronglyConnected(h)); got != want {
+		t.Errorf("want %d cycles, got %d", want, got)
+	}
+}
+
+func containsAddr(addrs []uint64, addr uint64) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAnonymizerRules(t *testing.T) {
+	objs := decodeDump(t, strings.NewReader(`{"address":"0x7fc96c000100", "type":"CLASS", "name":"Foo::Bar", "file":"/home/deploy/app/models/bar.rb"}
+{"address":"0x7fc96c000200", "type":"MODULE", "name":"Foo::Baz", "file":"/home/deploy/gems/baz.rb"}
+{"address":"0x7fc96c000300", "type":"CLASS", "name":"Baz", "file":"/usr/lib/ruby/baz.rb"}
+{"address":"0x7fc96c000001", "type":"OBJECT", "class":"0x7fc96c000100", "references":["0x7fc96c000300", "0x7fc96c000002"]}
+`))
+	anon := &rubyobj.Anonymizer{
+		Files: []rubyobj.FileRule{
+			{Prefix: "/home/deploy/app/", Replace: "app/"},
+			{Prefix: "/home/deploy/", Replace: ""},
+			{Prefix: "/home/", Replace: "never/"},
+		},
+		ScrubNames: true,
+	}
+	for i := range objs {
+		anon.Anonymize(&objs[i])
+	}
+
+	names := []string{"C1::C2", "C1::C3", "C3", ""}
+	files := []string{"app/models/bar.rb", "gems/baz.rb", "/usr/lib/ruby/baz.rb", ""}
+	for i, rObj := range objs {
+		if rObj.Name != names[i] || rObj.File != files[i] {
+			t.Errorf("want %q in %q, got %q in %q", names[i], files[i], rObj.Name, rObj.File)
+		}
+	}
+
+	// Addresses are given out in the order they're seen, like heap slots.
+	want := []uint64{0x100000000000, 0x100000000028, 0x100000000050, 0x100000000078}
+	got := []uint64{objs[0].Address, objs[1].Address, objs[2].Address, objs[3].Address}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("want addresses %x, got %x", want, got)
+			break
+		}
+	}
+	if objs[3].Class != objs[0].Address || objs[3].References[0] != objs[2].Address {
+		t.Errorf("the class and references of the object weren't remapped like the addresses: %+v", objs[3])
+	}
+	if objs[3].References[1] != 0x1000000000a0 {
+		t.Errorf("want a new address for an object not seen yet, got %x", objs[3].References[1])
+	}
+	if s := rubyobj.FormatAddress(objs[3].References[1]); len(s) != len("0x7fc96c000001") {
+		t.Errorf("want an address of 12 hex digits, got %s", s)
+	}
+}
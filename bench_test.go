@@ -25,11 +25,10 @@ func BenchmarkParallelDecode_HugeDump(b *testing.B)   { parallelDecode(b, "testd
 func decode(b *testing.B, filename string) {
 	r := jsonReader(b, filename)
 
-	var err error
-
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
 
+		var err error
 		dec := rubyobj.NewDecoder(r)
 		for err != io.EOF {
 			rObj := rubyobj.RubyObject{}
@@ -39,7 +38,7 @@ func decode(b *testing.B, filename string) {
 			}
 		}
 
-		r.Reset()
+		_, _ = r.Seek(0, io.SeekStart)
 	}
 }
 
@@ -59,7 +58,7 @@ func parallelDecode(b *testing.B, filename string) {
 		go readErr(&wg, errC, b)
 		wg.Wait()
 
-		r.Reset()
+		_, _ = r.Seek(0, io.SeekStart)
 	}
 }
 
@@ -103,12 +102,12 @@ func encode(b *testing.B, filename string) {
 	}
 }
 
-func jsonReader(b *testing.B, filename string) *bytes.Buffer {
+func jsonReader(b *testing.B, filename string) *bytes.Reader {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		b.Fatal(err)
 	}
-	return bytes.NewBuffer(data)
+	return bytes.NewReader(data)
 }
 
 func decodeAll(b *testing.B, r io.Reader) (objects []rubyobj.RubyObject) {
@@ -0,0 +1,197 @@
+package rubyobj
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// CollectionStat describes the ARRAY, HASH or STRUCT objects of a class that
+// were allocated at the same site.
+type CollectionStat struct {
+	Type  RubyType
+	Class string
+	Site  AllocSite
+
+	// Count is the number of collections, of which Empty have no elements
+	// but a capacity for some, or more memory than their slot, holding
+	// EmptyMemsize bytes.
+	Count        uint64
+	Empty        uint64
+	EmptyMemsize uint64
+
+	// Elements and Capacity are summed over all the collections.  Capacity
+	// is only known for arrays; for other types it's equal to Elements.
+	Elements uint64
+	Capacity uint64
+	Memsize  uint64
+}
+
+// WasteRatio is how many slots were allocated for each element actually
+// stored.  It's 0 when the collections are all empty.
+func (c CollectionStat) WasteRatio() float64 {
+	if c.Elements == 0 {
+		return 0
+	}
+	return float64(c.Capacity) / float64(c.Elements)
+}
+
+// Collection is a single ARRAY, HASH or STRUCT object.
+type Collection struct {
+	Address  uint64
+	Type     RubyType
+	Class    string
+	Site     AllocSite
+	Elements uint64
+	Capacity uint64
+	Memsize  uint64
+
+	class uint64
+}
+
+// emptySlotMemsize is the memsize of an object that fits its heap slot, one
+// RVALUE of 40 bytes on 64-bit Rubies.  Collections with no elements that
+// hold more than that have a table or buffer allocated for nothing.  Hashes
+// don't say their capacity, so that's the only way to tell they're empty.
+const emptySlotMemsize = 40
+
+type collectionKey struct {
+	typ   RubyType
+	class uint64
+	file  string
+	line  uint64
+}
+
+// CollectionReport finds empty-but-allocated, oversized and huge collections.
+// Objects are fed one by one with Add, so it can be used directly on the
+// output of ParallelDecode.
+type CollectionReport struct {
+	// Where, if set, ignores the objects for which it's false.
+	Where func(*RubyObject) bool
+
+	groups  map[collectionKey]*CollectionStat
+	biggest collectionHeap
+	top     int
+	classes classNames
+}
+
+// NewCollectionReport creates an empty report that will keep the top
+// biggest collections.
+func NewCollectionReport(top int) *CollectionReport {
+	return &CollectionReport{
+		groups:  make(map[collectionKey]*CollectionStat),
+		top:     top,
+		classes: make(classNames),
+	}
+}
+
+// Add accounts for rObj in the report if it's an ARRAY, a HASH or a STRUCT.
+func (r *CollectionReport) Add(rObj *RubyObject) {
+	r.classes.observe(rObj)
+
+	var elements, capacity uint64
+	switch rObj.Type {
+	case Array, Struct:
+		elements = rObj.Length
+		capacity = rObj.Capacity
+	case Hash:
+		elements = rObj.Size
+	default:
+		return
+	}
+	if r.Where != nil && !r.Where(rObj) {
+		return
+	}
+	empty := elements == 0 && (capacity != 0 || rObj.Memsize > emptySlotMemsize)
+	if capacity < elements {
+		capacity = elements
+	}
+
+	key := collectionKey{typ: rObj.Type, class: rObj.Class, file: rObj.File, line: rObj.Line}
+	stat, ok := r.groups[key]
+	if !ok {
+		stat = &CollectionStat{
+			Type: rObj.Type,
+			Site: AllocSite{File: rObj.File, Line: rObj.Line},
+		}
+		r.groups[key] = stat
+	}
+	stat.Count++
+	stat.Elements += elements
+	stat.Capacity += capacity
+	stat.Memsize += rObj.Memsize
+	if empty {
+		stat.Empty++
+		stat.EmptyMemsize += rObj.Memsize
+	}
+
+	if r.top <= 0 {
+		return
+	}
+	coll := Collection{
+		Address:  rObj.Address,
+		Type:     rObj.Type,
+		Site:     stat.Site,
+		Elements: elements,
+		Capacity: capacity,
+		Memsize:  rObj.Memsize,
+		class:    rObj.Class,
+	}
+	if len(r.biggest) < r.top {
+		heap.Push(&r.biggest, coll)
+	} else if r.biggest[0].Elements < elements {
+		r.biggest[0] = coll
+		heap.Fix(&r.biggest, 0)
+	}
+}
+
+// Groups returns the collections grouped by type, class and allocation site,
+// the ones holding the most memory while empty first.
+func (r *CollectionReport) Groups() []CollectionStat {
+	out := make([]CollectionStat, 0, len(r.groups))
+	for key, stat := range r.groups {
+		s := *stat
+		s.Class = r.classes.name(key.class)
+		out = append(out, s)
+	}
+	sort.Sort(byEmptyMemsize(out))
+	return out
+}
+
+// Biggest returns the collections with the most elements, biggest first.
+func (r *CollectionReport) Biggest() []Collection {
+	out := make([]Collection, len(r.biggest))
+	copy(out, r.biggest)
+	for i := range out {
+		out[i].Class = r.classes.name(out[i].class)
+	}
+	sort.Sort(sort.Reverse(collectionHeap(out)))
+	return out
+}
+
+type byEmptyMemsize []CollectionStat
+
+func (b byEmptyMemsize) Len() int      { return len(b) }
+func (b byEmptyMemsize) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byEmptyMemsize) Less(i, j int) bool {
+	if b[i].EmptyMemsize != b[j].EmptyMemsize {
+		return b[i].EmptyMemsize > b[j].EmptyMemsize
+	}
+	if b[i].Memsize != b[j].Memsize {
+		return b[i].Memsize > b[j].Memsize
+	}
+	return b[i].Count > b[j].Count
+}
+
+// collectionHeap is a min-heap of collections by number of elements.
+type collectionHeap []Collection
+
+func (c collectionHeap) Len() int            { return len(c) }
+func (c collectionHeap) Swap(i, j int)       { c[i], c[j] = c[j], c[i] }
+func (c collectionHeap) Less(i, j int) bool  { return c[i].Elements < c[j].Elements }
+func (c *collectionHeap) Push(x interface{}) { *c = append(*c, x.(Collection)) }
+func (c *collectionHeap) Pop() interface{} {
+	old := *c
+	x := old[len(old)-1]
+	*c = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,93 @@
+package rubyobj_test
+
+import (
+	"github.com/aybabtme/rubyobj"
+	"strings"
+	"testing"
+)
+
+func TestCollectionReport(t *testing.T) {
+	objs := decodeDump(t, strings.NewReader(`{"address":"0x7fc96c000100", "type":"CLASS", "name":"Array"}
+{"address":"0x7fc96c000200", "type":"CLASS", "name":"Hash"}
+{"address":"0x7fc96c000001", "type":"ARRAY", "class":"0x7fc96c000100", "length":0, "embedded":true, "file":"app.rb", "line":1, "memsize":40}
+{"address":"0x7fc96c000002", "type":"ARRAY", "class":"0x7fc96c000100", "length":0, "capacity":16, "file":"app.rb", "line":1, "memsize":168}
+{"address":"0x7fc96c000003", "type":"ARRAY", "class":"0x7fc96c000100", "length":2, "capacity":10, "file":"app.rb", "line":1, "memsize":120}
+{"address":"0x7fc96c000004", "type":"HASH", "class":"0x7fc96c000200", "size":0, "file":"app.rb", "line":2, "memsize":192}
+{"address":"0x7fc96c000005", "type":"HASH", "class":"0x7fc96c000200", "size":7, "file":"app.rb", "line":2, "memsize":400}
+{"address":"0x7fc96c000006", "type":"STRING", "value":"abc", "bytesize":3}
+`))
+	report := rubyobj.NewCollectionReport(2)
+	for i := range objs {
+		report.Add(&objs[i])
+	}
+
+	groups := report.Groups()
+	if len(groups) != 2 {
+		t.Fatalf("want 2 groups, got %+v", groups)
+	}
+	// The hash without elements holds more than its slot, and the array
+	// has a capacity, so both are empty.  The embedded array isn't.
+	hashes, arrays := groups[0], groups[1]
+	if arrays.Type != rubyobj.Array || arrays.Class != "Array" || arrays.Site.String() != "app.rb:1" {
+		t.Fatalf("want the arrays of app.rb:1 last, got %+v", arrays)
+	}
+	if arrays.Count != 3 || arrays.Empty != 1 || arrays.EmptyMemsize != 168 || arrays.Memsize != 328 {
+		t.Errorf("want 3 arrays, 1 empty holding 168 bytes, got %+v", arrays)
+	}
+	if arrays.Elements != 2 || arrays.Capacity != 26 || arrays.WasteRatio() != 13 {
+		t.Errorf("want 2 elements for a capacity of 26, got %+v", arrays)
+	}
+	if hashes.Type != rubyobj.Hash || hashes.Count != 2 || hashes.Empty != 1 || hashes.EmptyMemsize != 192 || hashes.Elements != 7 || hashes.Capacity != 7 {
+		t.Errorf("want 2 hashes, 1 empty holding 192 bytes, of 7 elements, got %+v", hashes)
+	}
+
+	biggest := report.Biggest()
+	if len(biggest) != 2 || biggest[0].Address != 0x7fc96c000005 || biggest[1].Address != 0x7fc96c000003 {
+		t.Errorf("want the hash of 7 elements then the array of 2, got %+v", biggest)
+	}
+	if biggest[0].Class != "Hash" {
+		t.Errorf("want the class name of the biggest collections, got %q", biggest[0].Class)
+	}
+
+	where := rubyobj.NewCollectionReport(0)
+	where.Where = func(rObj *rubyobj.RubyObject) bool { return rObj.Type == rubyobj.Hash }
+	for i := range objs {
+		where.Add(&objs[i])
+	}
+	if groups := where.Groups(); len(groups) != 1 || groups[0].Type != rubyobj.Hash {
+		t.Errorf("want only the hashes, got %+v", groups)
+	}
+	if biggest := where.Biggest(); len(biggest) != 0 {
+		t.Errorf("want no biggest collection kept, got %+v", biggest)
+	}
+}
+
+func TestCollectionReportEmptyWithoutCapacity(t *testing.T) {
+	// small.json was dumped by a Ruby that doesn't say capacities, so only
+	// its empty hashes holding more than a slot are known to be empty.
+	objs := decodeFile(t, "testdata/small.json")
+	report := rubyobj.NewCollectionReport(0)
+	var hashes, arrays uint64
+	for i := range objs {
+		report.Add(&objs[i])
+		switch {
+		case objs[i].Type == rubyobj.Hash && objs[i].Size == 0 && objs[i].Memsize > 40:
+			hashes++
+		case objs[i].Type == rubyobj.Array && objs[i].Length == 0:
+			arrays++
+		}
+	}
+	if hashes == 0 || arrays == 0 {
+		t.Fatal("small.json should have empty hashes and arrays")
+	}
+	var empty uint64
+	for _, g := range report.Groups() {
+		if g.Type != rubyobj.Hash && g.Empty != 0 {
+			t.Errorf("collections without a capacity or memsize were counted as empty: %+v", g)
+		}
+		empty += g.Empty
+	}
+	if empty != hashes {
+		t.Errorf("want %d empty hashes, got %d", hashes, empty)
+	}
+}
@@ -0,0 +1,209 @@
+package rubyobj
+
+import (
+	"sort"
+)
+
+// Edge is a reference from one object to another.  From is 0 when the
+// reference is held by a ROOT record.
+type Edge struct {
+	From uint64
+	To   uint64
+}
+
+// ClassCount is the number of objects of a class, and the memory they hold.
+type ClassCount struct {
+	Class   string
+	Count   uint64
+	Memsize uint64
+}
+
+// Component is a strongly connected component of the reference graph of a
+// heap: every member can reach every other member, so none of them can be
+// freed before the others.
+type Component struct {
+	// Members are the addresses of the objects in the component.
+	Members []uint64
+	Memsize uint64
+	// Classes breaks down the members by class, most common first.
+	Classes []ClassCount
+	// Entries are the references from objects outside of the component to
+	// its members.
+	Entries []Edge
+}
+
+// StronglyConnected finds the cycles of the heap using Tarjan's algorithm.
+// Only non-trivial components are returned, that is those having more than
+// one member or an object referencing itself.  They are sorted by decreasing
+// memsize.
+func StronglyConnected(h *Heap) []Component {
+	objs := h.Objects()
+	n := len(objs)
+
+	const unvisited = -1
+	index := make([]int32, n)
+	low := make([]int32, n)
+	onStack := make([]bool, n)
+	compOf := make([]int32, n)
+	for i := range index {
+		index[i] = unvisited
+		compOf[i] = unvisited
+	}
+
+	var stack []int32
+	var next int32
+	var comps [][]int32
+
+	type frame struct {
+		v    int32
+		edge int
+	}
+	var call []frame
+
+	visit := func(v int32) {
+		index[v] = next
+		low[v] = next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+		call = append(call, frame{v: v})
+	}
+
+	for s := 0; s < n; s++ {
+		if index[s] != unvisited {
+			continue
+		}
+		visit(int32(s))
+
+		for len(call) != 0 {
+			f := &call[len(call)-1]
+			v := f.v
+			refs := objs[v].References
+
+			if f.edge < len(refs) {
+				to := refs[f.edge]
+				f.edge++
+				wi, ok := h.index[to]
+				if !ok {
+					continue
+				}
+				w := int32(wi)
+				if index[w] == unvisited {
+					visit(w)
+				} else if onStack[w] && index[w] < low[v] {
+					low[v] = index[w]
+				}
+				continue
+			}
+
+			call = call[:len(call)-1]
+			if len(call) != 0 {
+				parent := call[len(call)-1].v
+				if low[v] < low[parent] {
+					low[parent] = low[v]
+				}
+			}
+			if low[v] != index[v] {
+				continue
+			}
+
+			var members []int32
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				members = append(members, w)
+				if w == v {
+					break
+				}
+			}
+			if len(members) == 1 && !referencesItself(&objs[v]) {
+				continue
+			}
+			for _, w := range members {
+				compOf[w] = int32(len(comps))
+			}
+			comps = append(comps, members)
+		}
+	}
+
+	rootEntries := make(map[int32][]Edge)
+	for _, root := range h.Roots() {
+		for _, to := range root.References {
+			wi, ok := h.index[to]
+			if !ok || compOf[wi] == unvisited {
+				continue
+			}
+			c := compOf[wi]
+			rootEntries[c] = append(rootEntries[c], Edge{To: to})
+		}
+	}
+
+	out := make([]Component, 0, len(comps))
+	for c, members := range comps {
+		comp := Component{Entries: rootEntries[int32(c)]}
+		classes := make(map[string]*ClassCount)
+
+		for _, w := range members {
+			rObj := &objs[w]
+			comp.Members = append(comp.Members, rObj.Address)
+			comp.Memsize += rObj.Memsize
+
+			name := h.ClassName(rObj)
+			cc, ok := classes[name]
+			if !ok {
+				cc = &ClassCount{Class: name}
+				classes[name] = cc
+			}
+			cc.Count++
+			cc.Memsize += rObj.Memsize
+
+			for _, from := range h.Referrers(rObj.Address) {
+				if fi, ok := h.index[from]; ok && compOf[fi] == int32(c) {
+					continue
+				}
+				comp.Entries = append(comp.Entries, Edge{From: from, To: rObj.Address})
+			}
+		}
+
+		for _, cc := range classes {
+			comp.Classes = append(comp.Classes, *cc)
+		}
+		sort.Sort(byClassCount(comp.Classes))
+
+		out = append(out, comp)
+	}
+	sort.Sort(componentsByMemsize(out))
+	return out
+}
+
+func referencesItself(rObj *RubyObject) bool {
+	for _, ref := range rObj.References {
+		if ref == rObj.Address {
+			return true
+		}
+	}
+	return false
+}
+
+type byClassCount []ClassCount
+
+func (b byClassCount) Len() int      { return len(b) }
+func (b byClassCount) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byClassCount) Less(i, j int) bool {
+	if b[i].Count != b[j].Count {
+		return b[i].Count > b[j].Count
+	}
+	return b[i].Class < b[j].Class
+}
+
+type componentsByMemsize []Component
+
+func (c componentsByMemsize) Len() int      { return len(c) }
+func (c componentsByMemsize) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c componentsByMemsize) Less(i, j int) bool {
+	if c[i].Memsize != c[j].Memsize {
+		return c[i].Memsize > c[j].Memsize
+	}
+	return len(c[i].Members) > len(c[j].Members)
+}
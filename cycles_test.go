@@ -0,0 +1,105 @@
+package rubyobj_test
+
+import (
+	"github.com/aybabtme/rubyobj"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// loadHeap loads a dump given inline.
+func loadHeap(t *testing.T, dump string) *rubyobj.Heap {
+	h, err := rubyobj.LoadHeap(strings.NewReader(dump), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+type edges []rubyobj.Edge
+
+func (e edges) Len() int      { return len(e) }
+func (e edges) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e edges) Less(i, j int) bool {
+	if e[i].To != e[j].To {
+		return e[i].To < e[j].To
+	}
+	return e[i].From < e[j].From
+}
+
+type addrs []uint64
+
+func (a addrs) Len() int           { return len(a) }
+func (a addrs) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a addrs) Less(i, j int) bool { return a[i] < a[j] }
+
+func TestStronglyConnected(t *testing.T) {
+	// a1 <-> a2 -> b1 <-> b2, c0 -> a1 outside of any cycle, e0 references
+	// itself and f0 doesn't.
+	h := loadHeap(t, `{"type":"ROOT", "root":"vm", "references":["0x7fc96c0000a1", "0x7fc96c0000c0"]}
+{"address":"0x7fc96c000100", "type":"CLASS", "name":"Node"}
+{"address":"0x7fc96c0000a1", "type":"OBJECT", "class":"0x7fc96c000100", "memsize":100, "references":["0x7fc96c0000a2"]}
+{"address":"0x7fc96c0000a2", "type":"ARRAY", "memsize":100, "references":["0x7fc96c0000a1", "0x7fc96c0000b1"]}
+{"address":"0x7fc96c0000b1", "type":"OBJECT", "class":"0x7fc96c000100", "memsize":50, "references":["0x7fc96c0000b2"]}
+{"address":"0x7fc96c0000b2", "type":"OBJECT", "class":"0x7fc96c000100", "memsize":50, "references":["0x7fc96c0000b1"]}
+{"address":"0x7fc96c0000c0", "type":"OBJECT", "memsize":1000, "references":["0x7fc96c0000a1"]}
+{"address":"0x7fc96c0000e0", "type":"OBJECT", "memsize":10, "references":["0x7fc96c0000e0"]}
+{"address":"0x7fc96c0000f0", "type":"OBJECT", "memsize":10000}
+`)
+	comps := rubyobj.StronglyConnected(h)
+	if len(comps) != 3 {
+		t.Fatalf("want 3 components, got %+v", comps)
+	}
+
+	want := []struct {
+		members []uint64
+		memsize uint64
+		entries []rubyobj.Edge
+	}{
+		{
+			[]uint64{0x7fc96c0000a1, 0x7fc96c0000a2}, 200,
+			[]rubyobj.Edge{{From: 0, To: 0x7fc96c0000a1}, {From: 0x7fc96c0000c0, To: 0x7fc96c0000a1}},
+		},
+		{
+			[]uint64{0x7fc96c0000b1, 0x7fc96c0000b2}, 100,
+			[]rubyobj.Edge{{From: 0x7fc96c0000a2, To: 0x7fc96c0000b1}},
+		},
+		{
+			[]uint64{0x7fc96c0000e0}, 10,
+			nil,
+		},
+	}
+	for i, comp := range comps {
+		sort.Sort(addrs(comp.Members))
+		sort.Sort(edges(comp.Entries))
+		if !reflect.DeepEqual(comp.Members, want[i].members) || comp.Memsize != want[i].memsize {
+			t.Errorf("component %d: want members %x of %d bytes, got %x of %d bytes",
+				i, want[i].members, want[i].memsize, comp.Members, comp.Memsize)
+		}
+		if len(comp.Entries) != len(want[i].entries) || (len(comp.Entries) != 0 && !reflect.DeepEqual(comp.Entries, want[i].entries)) {
+			t.Errorf("component %d: want entries %x, got %x", i, want[i].entries, comp.Entries)
+		}
+	}
+
+	classes := comps[1].Classes
+	if len(classes) != 1 || classes[0] != (rubyobj.ClassCount{Class: "Node", Count: 2, Memsize: 100}) {
+		t.Errorf("want 2 Node in the second component, got %+v", classes)
+	}
+	if classes := comps[0].Classes; len(classes) != 2 || classes[0].Count != 1 || classes[1].Count != 1 {
+		t.Errorf("want an object of each class in the first component, got %+v", classes)
+	}
+}
+
+func TestStronglyConnectedMerged(t *testing.T) {
+	// Linking the two cycles both ways makes them one component.
+	h := loadHeap(t, `{"address":"0x7fc96c0000a1", "type":"OBJECT", "references":["0x7fc96c0000a2"]}
+{"address":"0x7fc96c0000a2", "type":"OBJECT", "references":["0x7fc96c0000a1", "0x7fc96c0000b1"]}
+{"address":"0x7fc96c0000b1", "type":"OBJECT", "references":["0x7fc96c0000b2"]}
+{"address":"0x7fc96c0000b2", "type":"OBJECT", "references":["0x7fc96c0000b1", "0x7fc96c0000a1"]}
+`)
+	comps := rubyobj.StronglyConnected(h)
+	if len(comps) != 1 || len(comps[0].Members) != 4 || len(comps[0].Entries) != 0 {
+		t.Errorf("want one component of 4 objects and no entry, got %+v", comps)
+	}
+}
@@ -0,0 +1,84 @@
+package rubyobj
+
+import (
+	"sort"
+)
+
+// ClassDiff is how the instances of a class changed between two heaps.
+type ClassDiff struct {
+	Class  string
+	Before ClassCount
+	After  ClassCount
+}
+
+// CountDelta is how many more instances there are after than before.
+func (c ClassDiff) CountDelta() int64 { return int64(c.After.Count) - int64(c.Before.Count) }
+
+// MemsizeDelta is how much more memory the instances hold after than before.
+func (c ClassDiff) MemsizeDelta() int64 { return int64(c.After.Memsize) - int64(c.Before.Memsize) }
+
+// DiffHeaps compares the instances of each class in two heaps, the classes
+// that grew the most first.  Classes are matched by name.
+func DiffHeaps(before, after *Heap) []ClassDiff {
+	diffs := make(map[string]*ClassDiff)
+	get := func(class string) *ClassDiff {
+		d, ok := diffs[class]
+		if !ok {
+			d = &ClassDiff{Class: class}
+			d.Before.Class = class
+			d.After.Class = class
+			diffs[class] = d
+		}
+		return d
+	}
+	for i := range before.objects {
+		rObj := &before.objects[i]
+		d := get(before.ClassName(rObj))
+		d.Before.Count++
+		d.Before.Memsize += rObj.Memsize
+	}
+	for i := range after.objects {
+		rObj := &after.objects[i]
+		d := get(after.ClassName(rObj))
+		d.After.Count++
+		d.After.Memsize += rObj.Memsize
+	}
+
+	out := make([]ClassDiff, 0, len(diffs))
+	for _, d := range diffs {
+		out = append(out, *d)
+	}
+	sort.Sort(byMemsizeDelta(out))
+	return out
+}
+
+// AddedObjects are the addresses of the objects of after that aren't in
+// before.  Since Ruby reuses the slots of freed objects, an object is also
+// considered new when the one at its address changed type, class or
+// allocation generation.
+func AddedObjects(before, after *Heap) []uint64 {
+	var added []uint64
+	for i := range after.objects {
+		rObj := &after.objects[i]
+		old, ok := before.Lookup(rObj.Address)
+		if !ok || old.Type != rObj.Type || old.Generation != rObj.Generation ||
+			before.ClassName(old) != after.ClassName(rObj) {
+			added = append(added, rObj.Address)
+		}
+	}
+	return added
+}
+
+type byMemsizeDelta []ClassDiff
+
+func (b byMemsizeDelta) Len() int      { return len(b) }
+func (b byMemsizeDelta) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byMemsizeDelta) Less(i, j int) bool {
+	if b[i].MemsizeDelta() != b[j].MemsizeDelta() {
+		return b[i].MemsizeDelta() > b[j].MemsizeDelta()
+	}
+	if b[i].CountDelta() != b[j].CountDelta() {
+		return b[i].CountDelta() > b[j].CountDelta()
+	}
+	return b[i].Class < b[j].Class
+}
@@ -0,0 +1,83 @@
+package rubyobj_test
+
+import (
+	"fmt"
+	"github.com/aybabtme/rubyobj"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Foo is at another address after, Gone disappears and New appears.
+const (
+	diffBefore = `{"address":"0x7fc96c000100", "type":"CLASS", "name":"Foo"}
+{"address":"0x7fc96c000200", "type":"CLASS", "name":"Bar"}
+{"address":"0x7fc96c000300", "type":"CLASS", "name":"Gone"}
+{"address":"0x7fc96c000001", "type":"OBJECT", "class":"0x7fc96c000100", "generation":1, "memsize":10}
+{"address":"0x7fc96c000002", "type":"OBJECT", "class":"0x7fc96c000100", "generation":1, "memsize":10}
+{"address":"0x7fc96c000003", "type":"OBJECT", "class":"0x7fc96c000200", "generation":1, "memsize":100}
+{"address":"0x7fc96c000004", "type":"OBJECT", "class":"0x7fc96c000300", "generation":1, "memsize":50}
+{"address":"0x7fc96c000005", "type":"STRING", "generation":1, "memsize":5}
+{"address":"0x7fc96c000008", "type":"STRING", "generation":1}
+`
+	diffAfter = `{"address":"0x7fc96c000110", "type":"CLASS", "name":"Foo"}
+{"address":"0x7fc96c000200", "type":"CLASS", "name":"Bar"}
+{"address":"0x7fc96c000400", "type":"CLASS", "name":"New"}
+{"address":"0x7fc96c000001", "type":"OBJECT", "class":"0x7fc96c000110", "generation":1, "memsize":10}
+{"address":"0x7fc96c000002", "type":"OBJECT", "class":"0x7fc96c000110", "generation":2, "memsize":10}
+{"address":"0x7fc96c000006", "type":"OBJECT", "class":"0x7fc96c000110", "generation":3, "memsize":10}
+{"address":"0x7fc96c000003", "type":"OBJECT", "class":"0x7fc96c000200", "generation":1, "memsize":100}
+{"address":"0x7fc96c000004", "type":"OBJECT", "class":"0x7fc96c000400", "generation":1, "memsize":30}
+{"address":"0x7fc96c000007", "type":"OBJECT", "class":"0x7fc96c000400", "generation":1, "memsize":30}
+{"address":"0x7fc96c000005", "type":"STRING", "generation":1, "memsize":5}
+{"address":"0x7fc96c000008", "type":"ARRAY", "generation":1}
+`
+)
+
+func TestDiffHeaps(t *testing.T) {
+	before, after := loadHeap(t, diffBefore), loadHeap(t, diffAfter)
+
+	var got []string
+	for _, d := range rubyobj.DiffHeaps(before, after) {
+		if d.Before.Class != d.Class || d.After.Class != d.Class {
+			t.Errorf("want the counts of %s named after it, got %+v", d.Class, d)
+		}
+		got = append(got, fmt.Sprintf("%s %d/%d -> %d/%d %+d/%+d", d.Class,
+			d.Before.Count, d.Before.Memsize, d.After.Count, d.After.Memsize, d.CountDelta(), d.MemsizeDelta()))
+	}
+	// Growing the most first, then by name.  The classes and the strings
+	// have no class.
+	want := []string{
+		"New 0/0 -> 2/60 +2/+60",
+		"Foo 2/20 -> 3/30 +1/+10",
+		"0x000000000000 5/5 -> 5/5 +0/+0",
+		"Bar 1/100 -> 1/100 +0/+0",
+		"Gone 1/50 -> 0/0 -1/-50",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want diffs %q, got %q", want, got)
+	}
+}
+
+func TestAddedObjects(t *testing.T) {
+	before, after := loadHeap(t, diffBefore), loadHeap(t, diffAfter)
+
+	added := rubyobj.AddedObjects(before, after)
+	sort.Sort(addrs(added))
+	want := []uint64{
+		0x7fc96c000002, // another generation
+		0x7fc96c000004, // another class
+		0x7fc96c000006, // new address
+		0x7fc96c000007, // new address
+		0x7fc96c000008, // another type
+		0x7fc96c000110, // Foo moved
+		0x7fc96c000400, // New
+	}
+	if !reflect.DeepEqual(added, want) {
+		t.Errorf("want added %x, got %x", want, added)
+	}
+
+	if added := rubyobj.AddedObjects(after, after); len(added) != 0 {
+		t.Errorf("want nothing added to the same heap, got %x", added)
+	}
+}
@@ -0,0 +1,213 @@
+package rubyobj
+
+// DominatorTree is the dominator tree of the reference graph of a heap,
+// rooted at a virtual node referencing everything the ROOT records reference.
+// An object dominates another when every path from the roots to the latter
+// goes through the former: freeing the dominator would free the dominated.
+type DominatorTree struct {
+	h *Heap
+	// idom is the index of the immediate dominator of each object, or -1 when
+	// it is only dominated by the roots.
+	idom     []int32
+	retained []uint64
+}
+
+// NewDominatorTree computes the dominators of the objects of h using the
+// Lengauer-Tarjan algorithm.  Objects that aren't reachable from any ROOT
+// record are considered to be dominated by the roots.
+func NewDominatorTree(h *Heap) *DominatorTree {
+	objs := h.Objects()
+	n := len(objs)
+
+	// Vertices are numbered 0 for the virtual root and i+1 for objs[i].
+	var rootSuccs []int32
+	for _, root := range h.Roots() {
+		for _, to := range root.References {
+			if i, ok := h.index[to]; ok {
+				rootSuccs = append(rootSuccs, int32(i)+1)
+			}
+		}
+	}
+	// succ returns the vertex referenced by the edge-th reference of v, or -1
+	// if it isn't in the heap.  ok is false past the last reference.
+	succ := func(v int32, edge int) (w int32, ok bool) {
+		if v == 0 {
+			if edge >= len(rootSuccs) {
+				return -1, false
+			}
+			return rootSuccs[edge], true
+		}
+		refs := objs[v-1].References
+		if edge >= len(refs) {
+			return -1, false
+		}
+		if i, ok := h.index[refs[edge]]; ok {
+			return int32(i) + 1, true
+		}
+		return -1, true
+	}
+
+	// Depth first numbering, 1-based so that 0 means unvisited.
+	dfnum := make([]int32, n+1)
+	vertex := make([]int32, 1, n+2) // vertex[dfnum[v]] == v
+	parent := make([]int32, n+2)
+
+	type frame struct {
+		v    int32
+		edge int
+	}
+	var call []frame
+	var count int32
+	visit := func(v, from int32) {
+		count++
+		dfnum[v] = count
+		vertex = append(vertex, v)
+		parent[count] = dfnum[from]
+		call = append(call, frame{v: v})
+	}
+	visit(0, 0)
+	for len(call) != 0 {
+		f := &call[len(call)-1]
+		w, ok := succ(f.v, f.edge)
+		if !ok {
+			call = call[:len(call)-1]
+			continue
+		}
+		f.edge++
+		if w >= 0 && dfnum[w] == 0 {
+			visit(w, f.v)
+		}
+	}
+
+	// Everything below works on depth first numbers.
+	N := count
+	semi := make([]int32, N+1)
+	label := make([]int32, N+1)
+	ancestor := make([]int32, N+1)
+	idom := make([]int32, N+1)
+	bucketHead := make([]int32, N+1)
+	bucketNext := make([]int32, N+1)
+	for i := int32(1); i <= N; i++ {
+		semi[i] = i
+		label[i] = i
+	}
+
+	preds := make([][]int32, N+1)
+	for i := int32(1); i <= N; i++ {
+		for edge := 0; ; edge++ {
+			w, ok := succ(vertex[i], edge)
+			if !ok {
+				break
+			}
+			if w >= 0 {
+				preds[dfnum[w]] = append(preds[dfnum[w]], i)
+			}
+		}
+	}
+
+	var path []int32
+	eval := func(v int32) int32 {
+		if ancestor[v] == 0 {
+			return v
+		}
+		path = path[:0]
+		for u := v; ancestor[ancestor[u]] != 0; u = ancestor[u] {
+			path = append(path, u)
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			u := path[i]
+			a := ancestor[u]
+			if semi[label[a]] < semi[label[u]] {
+				label[u] = label[a]
+			}
+			ancestor[u] = ancestor[a]
+		}
+		return label[v]
+	}
+
+	for w := N; w >= 2; w-- {
+		for _, v := range preds[w] {
+			if u := eval(v); semi[u] < semi[w] {
+				semi[w] = semi[u]
+			}
+		}
+		s := semi[w]
+		bucketNext[w] = bucketHead[s]
+		bucketHead[s] = w
+
+		p := parent[w]
+		ancestor[w] = p
+
+		for v := bucketHead[p]; v != 0; v = bucketNext[v] {
+			if u := eval(v); semi[u] < semi[v] {
+				idom[v] = u
+			} else {
+				idom[v] = p
+			}
+		}
+		bucketHead[p] = 0
+	}
+	for w := int32(2); w <= N; w++ {
+		if idom[w] != semi[w] {
+			idom[w] = idom[idom[w]]
+		}
+	}
+
+	d := &DominatorTree{
+		h:        h,
+		idom:     make([]int32, n),
+		retained: make([]uint64, n),
+	}
+	for i := range objs {
+		d.idom[i] = -1
+		d.retained[i] = objs[i].Memsize
+	}
+	for w := N; w >= 2; w-- {
+		obj := vertex[w] - 1
+		dom := vertex[idom[w]] - 1
+		d.idom[obj] = dom
+		if dom >= 0 {
+			d.retained[dom] += d.retained[obj]
+		}
+	}
+	return d
+}
+
+// ImmediateDominator is the address of the closest object dominating the
+// object at addr.  It's false if only the roots dominate it.
+func (d *DominatorTree) ImmediateDominator(addr uint64) (uint64, bool) {
+	i, ok := d.h.index[addr]
+	if !ok || d.idom[i] < 0 {
+		return 0, false
+	}
+	return d.h.objects[d.idom[i]].Address, true
+}
+
+// Dominates tells if the object at dom dominates the object at addr.  An
+// object dominates itself.
+func (d *DominatorTree) Dominates(dom, addr uint64) bool {
+	di, ok := d.h.index[dom]
+	if !ok {
+		return false
+	}
+	i, ok := d.h.index[addr]
+	if !ok {
+		return false
+	}
+	for j := int32(i); j >= 0; j = d.idom[j] {
+		if j == int32(di) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetainedSize is the memory that would be freed if the object at addr was:
+// its own memsize and that of every object it dominates.
+func (d *DominatorTree) RetainedSize(addr uint64) uint64 {
+	i, ok := d.h.index[addr]
+	if !ok {
+		return 0
+	}
+	return d.retained[i]
+}
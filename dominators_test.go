@@ -0,0 +1,109 @@
+package rubyobj_test
+
+import (
+	"github.com/aybabtme/rubyobj"
+	"testing"
+)
+
+func TestDominatorTree(t *testing.T) {
+	const (
+		r  = 0x7fc96c000001
+		a  = 0x7fc96c000002
+		b  = 0x7fc96c000003
+		d  = 0x7fc96c000004
+		e  = 0x7fc96c000005
+		c1 = 0x7fc96c000006
+		c2 = 0x7fc96c000007
+		u  = 0x7fc96c000008
+	)
+	// A diamond r -> a, b -> d -> e, a cycle c1 <-> c2 below r, and u which
+	// isn't reachable from the roots but references d.
+	h := loadHeap(t, `{"type":"ROOT", "root":"vm", "references":["0x7fc96c000001"]}
+{"address":"0x7fc96c000001", "type":"OBJECT", "memsize":1, "references":["0x7fc96c000002", "0x7fc96c000003", "0x7fc96c000006"]}
+{"address":"0x7fc96c000002", "type":"OBJECT", "memsize":2, "references":["0x7fc96c000004"]}
+{"address":"0x7fc96c000003", "type":"OBJECT", "memsize":4, "references":["0x7fc96c000004"]}
+{"address":"0x7fc96c000004", "type":"OBJECT", "memsize":8, "references":["0x7fc96c000005"]}
+{"address":"0x7fc96c000005", "type":"OBJECT", "memsize":16}
+{"address":"0x7fc96c000006", "type":"OBJECT", "memsize":32, "references":["0x7fc96c000007"]}
+{"address":"0x7fc96c000007", "type":"OBJECT", "memsize":64, "references":["0x7fc96c000006"]}
+{"address":"0x7fc96c000008", "type":"OBJECT", "memsize":128, "references":["0x7fc96c000004"]}
+`)
+	tree := rubyobj.NewDominatorTree(h)
+
+	idoms := []struct {
+		addr, idom uint64
+		ok         bool
+	}{
+		{r, 0, false},
+		{a, r, true},
+		{b, r, true},
+		{d, r, true},
+		{e, d, true},
+		{c1, r, true},
+		{c2, c1, true},
+		{u, 0, false},
+	}
+	for _, tt := range idoms {
+		idom, ok := tree.ImmediateDominator(tt.addr)
+		if ok != tt.ok || (ok && idom != tt.idom) {
+			t.Errorf("want %s immediately dominated by %s (%v), got %s (%v)",
+				rubyobj.FormatAddress(tt.addr), rubyobj.FormatAddress(tt.idom), tt.ok,
+				rubyobj.FormatAddress(idom), ok)
+		}
+	}
+
+	dominates := []struct {
+		dom, addr uint64
+		want      bool
+	}{
+		{r, e, true},
+		{d, e, true},
+		{d, d, true},
+		{a, d, false},
+		{b, d, false},
+		{u, d, false},
+		{c1, c2, true},
+		{c2, c1, false},
+		{e, r, false},
+		{r, u, false},
+	}
+	for _, tt := range dominates {
+		if got := tree.Dominates(tt.dom, tt.addr); got != tt.want {
+			t.Errorf("want %s dominating %s: %v, got %v",
+				rubyobj.FormatAddress(tt.dom), rubyobj.FormatAddress(tt.addr), tt.want, got)
+		}
+	}
+
+	retained := []struct {
+		addr, want uint64
+	}{
+		{r, 1 + 2 + 4 + 8 + 16 + 32 + 64},
+		{a, 2},
+		{b, 4},
+		{d, 8 + 16},
+		{e, 16},
+		{c1, 32 + 64},
+		{c2, 64},
+		{u, 128},
+	}
+	for _, tt := range retained {
+		if got := tree.RetainedSize(tt.addr); got != tt.want {
+			t.Errorf("want %s retaining %d bytes, got %d", rubyobj.FormatAddress(tt.addr), tt.want, got)
+		}
+	}
+}
+
+func TestDominatorTreeSelfLoop(t *testing.T) {
+	// An object referencing itself still dominates what it references.
+	h := loadHeap(t, `{"type":"ROOT", "root":"vm", "references":["0x7fc96c000001"]}
+{"address":"0x7fc96c000001", "type":"OBJECT", "memsize":1, "references":["0x7fc96c000001", "0x7fc96c000002"]}
+{"address":"0x7fc96c000002", "type":"OBJECT", "memsize":2}
+`)
+	tree := rubyobj.NewDominatorTree(h)
+	if idom, ok := tree.ImmediateDominator(0x7fc96c000002); !ok || idom != 0x7fc96c000001 {
+		t.Errorf("want 0x7fc96c000002 dominated by 0x7fc96c000001, got %x (%v)", idom, ok)
+	}
+	if got := tree.RetainedSize(0x7fc96c000001); got != 3 {
+		t.Errorf("want 3 bytes retained, got %d", got)
+	}
+}
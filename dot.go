@@ -0,0 +1,216 @@
+package rubyobj
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DOTOptions limits the subgraph rendered by WriteDOT.
+type DOTOptions struct {
+	// Depth is how many references to follow from the seeds, both to the
+	// objects they reference and to the objects referencing them.  Other
+	// objects, like those sharing a referrer with the seeds, aren't drawn.
+	Depth int
+	// MaxNodes and MaxEdges cap the size of the graph, 0 meaning no limit.
+	MaxNodes int
+	MaxEdges int
+}
+
+// dotColors are the fill colors of the nodes, by RubyType.
+var dotColors = map[RubyType]string{
+	Array:    "lightblue",
+	Class:    "gold",
+	Data:     "salmon",
+	Hash:     "lightskyblue",
+	Iclass:   "khaki",
+	Module:   "goldenrod",
+	Node:     "gray",
+	Object:   "palegreen",
+	Regexp:   "plum",
+	String:   "lightyellow",
+	Struct:   "aquamarine",
+	Symbol:   "pink",
+	Float:    "lavender",
+	Bignum:   "lavender",
+	Rational: "lavender",
+	Complex:  "lavender",
+	File:     "orange",
+	Match:    "thistle",
+	Zombie:   "black",
+}
+
+// WriteDOT renders the neighbourhood of the seed objects as a Graphviz
+// digraph.  Nodes are labelled with their type, class, value and memsize and
+// colored by type; seeds are drawn bold and objects held by a ROOT record are
+// double circled.
+func WriteDOT(w io.Writer, h *Heap, seeds []uint64, opts DOTOptions) error {
+	nodes := neighbourhood(h, seeds, opts.Depth, opts.MaxNodes)
+	isSeed := make(map[uint64]bool, len(seeds))
+	for _, seed := range seeds {
+		isSeed[seed] = true
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph heap {\n")
+	fmt.Fprintf(bw, "  node [shape=box, style=filled, fontname=\"monospace\"];\n")
+
+	inGraph := make(map[uint64]bool, len(nodes))
+	for _, addr := range nodes {
+		inGraph[addr] = true
+		rObj, _ := h.Lookup(addr)
+
+		attrs := []string{
+			"label=" + dotQuote(nodeLabel(h, rObj)),
+		}
+		if color, ok := dotColors[rObj.Type]; ok {
+			attrs = append(attrs, "fillcolor="+color)
+		} else {
+			attrs = append(attrs, "fillcolor=white")
+		}
+		if rObj.Type == Zombie {
+			attrs = append(attrs, "fontcolor=white")
+		}
+		if isSeed[addr] {
+			attrs = append(attrs, "penwidth=3")
+		}
+		if h.IsRoot(addr) {
+			attrs = append(attrs, "peripheries=2")
+		}
+		fmt.Fprintf(bw, "  %s [%s];\n", dotQuote(FormatAddress(addr)), strings.Join(attrs, ", "))
+	}
+
+	edges := 0
+outer:
+	for _, from := range nodes {
+		rObj, _ := h.Lookup(from)
+		drawn := make(map[uint64]bool)
+		for _, to := range rObj.References {
+			if !inGraph[to] || drawn[to] {
+				continue
+			}
+			drawn[to] = true
+			if opts.MaxEdges > 0 && edges >= opts.MaxEdges {
+				break outer
+			}
+			edges++
+			fmt.Fprintf(bw, "  %s -> %s;\n", dotQuote(FormatAddress(from)), dotQuote(FormatAddress(to)))
+		}
+	}
+
+	fmt.Fprintf(bw, "}\n")
+	return bw.Flush()
+}
+
+// neighbourhood walks the references of the heap breadth first, from the
+// seeds to the objects they reference and from the seeds to the objects
+// referencing them, up to depth references away.  The walks are kept apart,
+// so that the other objects referenced by an ancestor of the seeds, or
+// referencing one of their descendants, are left out.  It returns the
+// addresses of the objects found, seeds first, stopping at maxNodes objects
+// unless maxNodes is 0.
+func neighbourhood(h *Heap, seeds []uint64, depth, maxNodes int) []uint64 {
+	seen := make(map[uint64]bool)
+	var nodes []uint64
+
+	add := func(addr uint64) bool {
+		if maxNodes > 0 && len(nodes) >= maxNodes {
+			return false
+		}
+		if !seen[addr] {
+			seen[addr] = true
+			nodes = append(nodes, addr)
+		}
+		return true
+	}
+
+	var start []uint64
+	for _, seed := range seeds {
+		if _, ok := h.Lookup(seed); !ok {
+			continue
+		}
+		if !add(seed) {
+			return nodes
+		}
+		start = append(start, seed)
+	}
+
+	walk := func(next func(addr uint64) []uint64) bool {
+		visited := make(map[uint64]bool)
+		for _, seed := range start {
+			visited[seed] = true
+		}
+		frontier := start
+		for d := 0; d < depth && len(frontier) != 0; d++ {
+			var found []uint64
+			for _, addr := range frontier {
+				for _, to := range next(addr) {
+					if visited[to] {
+						continue
+					}
+					if _, ok := h.Lookup(to); !ok {
+						continue
+					}
+					visited[to] = true
+					if !add(to) {
+						return false
+					}
+					found = append(found, to)
+				}
+			}
+			frontier = found
+		}
+		return true
+	}
+	references := func(addr uint64) []uint64 {
+		rObj, _ := h.Lookup(addr)
+		return rObj.References
+	}
+	if walk(references) {
+		walk(h.Referrers)
+	}
+	return nodes
+}
+
+// nodeLabel describes rObj on a few lines.
+func nodeLabel(h *Heap, rObj *RubyObject) string {
+	lines := []string{rObj.Type.Name()}
+	if rObj.Class != 0 {
+		lines = append(lines, h.ClassName(rObj))
+	}
+	if rObj.Name != "" {
+		lines = append(lines, rObj.Name)
+	}
+	if value := valuePreview(rObj, 32); value != "" {
+		lines = append(lines, value)
+	}
+	lines = append(lines, fmt.Sprintf("%d bytes", rObj.Memsize))
+	return strings.Join(lines, "\n")
+}
+
+// valuePreview is a short representation of the value of rObj, if it has
+// any.
+func valuePreview(rObj *RubyObject, max int) string {
+	switch v := rObj.Value.(type) {
+	case string:
+		if v == "" && rObj.Type != String {
+			return ""
+		}
+		if len(v) > max {
+			v = v[:max] + "..."
+		}
+		return fmt.Sprintf("%q", v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dotQuote quotes str as a DOT identifier.
+func dotQuote(str string) string {
+	return `"` + dotEscaper.Replace(str) + `"`
+}
@@ -0,0 +1,58 @@
+package rubyobj_test
+
+import (
+	"bytes"
+	"github.com/aybabtme/rubyobj"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var dotNode = regexp.MustCompile(`(?m)^  "0x7fc96c0000(\w\w)" \[`)
+
+// dotNodes are the last byte of the addresses of the nodes of a graph.
+func dotNodes(graph string) []string {
+	var nodes []string
+	for _, m := range dotNode.FindAllStringSubmatch(graph, -1) {
+		nodes = append(nodes, m[1])
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func TestWriteDOTNeighbourhood(t *testing.T) {
+	// a2 -> a1 -> seed -> d1 -> d2, a1 also references the sibling s1, and
+	// c1 references d1 too.
+	h, err := rubyobj.LoadHeap(strings.NewReader(`{"address":"0x7fc96c0000a2", "type":"OBJECT", "references":["0x7fc96c0000a1"]}
+{"address":"0x7fc96c0000a1", "type":"OBJECT", "references":["0x7fc96c000000", "0x7fc96c0000b1"]}
+{"address":"0x7fc96c0000b1", "type":"OBJECT"}
+{"address":"0x7fc96c000000", "type":"OBJECT", "references":["0x7fc96c0000d1"]}
+{"address":"0x7fc96c0000c1", "type":"OBJECT", "references":["0x7fc96c0000d1"]}
+{"address":"0x7fc96c0000d1", "type":"OBJECT", "references":["0x7fc96c0000d2"]}
+{"address":"0x7fc96c0000d2", "type":"OBJECT"}
+`), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts rubyobj.DOTOptions
+		want []string
+	}{
+		{rubyobj.DOTOptions{Depth: 0}, []string{"00"}},
+		{rubyobj.DOTOptions{Depth: 1}, []string{"00", "a1", "d1"}},
+		{rubyobj.DOTOptions{Depth: 2}, []string{"00", "a1", "a2", "d1", "d2"}},
+		{rubyobj.DOTOptions{Depth: 5}, []string{"00", "a1", "a2", "d1", "d2"}},
+		{rubyobj.DOTOptions{Depth: 2, MaxNodes: 3}, []string{"00", "d1", "d2"}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := rubyobj.WriteDOT(&buf, h, []uint64{0x7fc96c000000}, tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := dotNodes(buf.String()); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%+v: want nodes %v, got %v", tt.opts, tt.want, got)
+		}
+	}
+}
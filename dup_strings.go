@@ -0,0 +1,194 @@
+package rubyobj
+
+import (
+	"sort"
+)
+
+// DuplicateString describes a string content found in many STRING objects.
+type DuplicateString struct {
+	Value    string
+	Encoding string
+	Bytesize uint64
+
+	// Count is the number of STRING objects with this content, of which
+	// Frozen are frozen and Shared share their buffer with another string.
+	Count  uint64
+	Frozen uint64
+	Shared uint64
+	// Fstring tells if one of the copies is an interned fstring, in which case
+	// the other copies could be replaced by it.
+	Fstring bool
+
+	// WastedBytes is the memory held by the buffers of the copies beyond the
+	// first one.
+	WastedBytes uint64
+
+	// Sites are the allocation sites of the copies holding the most memory.
+	Sites []AllocSiteStat
+}
+
+type dupStringKey struct {
+	value    string
+	encoding string
+	bytesize uint64
+}
+
+type dupStringAcc struct {
+	DuplicateString
+	ownedBytes uint64
+	sites      map[AllocSite]*AllocSiteStat
+}
+
+// DuplicateStringReport finds the STRING objects that have the same content.
+// Objects are fed one by one with Add, so it can be used directly on the
+// output of ParallelDecode.
+//
+// Ruby only dumps the value of ASCII strings, so the others can't be told
+// apart and are left out.  Shared strings have no value either, they count
+// as a copy of the string whose buffer they reference.
+type DuplicateStringReport struct {
+	strs map[dupStringKey]*dupStringAcc
+	// keys of the strings by address, and the shared strings by the address
+	// of their buffer, not yet counted as it hasn't been seen.
+	keys   map[uint64]dupStringKey
+	shared map[uint64][]stringCopy
+}
+
+type stringCopy struct {
+	frozen  bool
+	fstring bool
+	site    AllocSite
+	memsize uint64
+}
+
+// maxDupStringSites is how many allocation sites are kept in
+// DuplicateString.Sites.
+const maxDupStringSites = 3
+
+// NewDuplicateStringReport creates an empty report.
+func NewDuplicateStringReport() *DuplicateStringReport {
+	return &DuplicateStringReport{
+		strs:   make(map[dupStringKey]*dupStringAcc),
+		keys:   make(map[uint64]dupStringKey),
+		shared: make(map[uint64][]stringCopy),
+	}
+}
+
+// Add accounts for rObj in the report if it's a STRING.
+func (r *DuplicateStringReport) Add(rObj *RubyObject) {
+	if rObj.Type != String {
+		return
+	}
+	site := AllocSite{File: rObj.File, Line: rObj.Line}
+	if rObj.Shared() {
+		if len(rObj.References) != 0 {
+			buf := rObj.References[0]
+			r.shared[buf] = append(r.shared[buf], stringCopy{rObj.Frozen(), rObj.Fstring(), site, rObj.Memsize})
+		}
+		return
+	}
+	value, _ := rObj.Value.(string)
+	if value == "" && rObj.Bytesize != 0 {
+		return
+	}
+	key := dupStringKey{value: value, encoding: rObj.Encoding, bytesize: rObj.Bytesize}
+	r.keys[rObj.Address] = key
+
+	acc, ok := r.strs[key]
+	if !ok {
+		acc = &dupStringAcc{
+			DuplicateString: DuplicateString{
+				Value:    value,
+				Encoding: rObj.Encoding,
+				Bytesize: rObj.Bytesize,
+			},
+			sites: make(map[AllocSite]*AllocSiteStat),
+		}
+		r.strs[key] = acc
+	}
+	acc.ownedBytes += rObj.Bytesize
+	acc.add(stringCopy{rObj.Frozen(), rObj.Fstring(), site, rObj.Memsize})
+}
+
+// add counts a copy of the string.
+func (acc *dupStringAcc) add(c stringCopy) {
+	acc.Count++
+	if c.frozen {
+		acc.Frozen++
+	}
+	if c.fstring {
+		acc.Fstring = true
+	}
+	if c.site.File != "" {
+		stat, ok := acc.sites[c.site]
+		if !ok {
+			stat = &AllocSiteStat{AllocSite: c.site}
+			acc.sites[c.site] = stat
+		}
+		stat.Count++
+		stat.Memsize += c.memsize
+	}
+}
+
+// countShared counts the shared strings whose buffer has been seen.
+func (r *DuplicateStringReport) countShared() {
+	for buf, copies := range r.shared {
+		key, ok := r.keys[buf]
+		if !ok {
+			continue
+		}
+		acc := r.strs[key]
+		for _, c := range copies {
+			acc.add(c)
+			acc.Shared++
+		}
+		delete(r.shared, buf)
+	}
+}
+
+// Duplicates returns the string contents found in at least minCount objects,
+// the ones wasting the most memory first.
+func (r *DuplicateStringReport) Duplicates(minCount uint64) []DuplicateString {
+	if minCount < 2 {
+		minCount = 2
+	}
+	r.countShared()
+	var out []DuplicateString
+	for _, acc := range r.strs {
+		if acc.Count < minCount {
+			continue
+		}
+		dup := acc.DuplicateString
+		if acc.ownedBytes > dup.Bytesize {
+			dup.WastedBytes = acc.ownedBytes - dup.Bytesize
+		}
+
+		sites := make([]AllocSiteStat, 0, len(acc.sites))
+		for _, stat := range acc.sites {
+			sites = append(sites, *stat)
+		}
+		sort.Sort(byMemsize(sites))
+		if len(sites) > maxDupStringSites {
+			sites = sites[:maxDupStringSites]
+		}
+		dup.Sites = sites
+
+		out = append(out, dup)
+	}
+	sort.Sort(byWastedBytes(out))
+	return out
+}
+
+type byWastedBytes []DuplicateString
+
+func (b byWastedBytes) Len() int      { return len(b) }
+func (b byWastedBytes) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byWastedBytes) Less(i, j int) bool {
+	if b[i].WastedBytes != b[j].WastedBytes {
+		return b[i].WastedBytes > b[j].WastedBytes
+	}
+	if b[i].Count != b[j].Count {
+		return b[i].Count > b[j].Count
+	}
+	return b[i].Value < b[j].Value
+}
@@ -0,0 +1,104 @@
+package rubyobj_test
+
+import (
+	"github.com/aybabtme/rubyobj"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// decodeFile decodes all the objects of a dump of testdata, in order.
+func decodeFile(t *testing.T, filename string) []rubyobj.RubyObject {
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return decodeDump(t, f)
+}
+
+// decodeDump decodes all the objects of a dump, in order.
+func decodeDump(t *testing.T, r io.Reader) []rubyobj.RubyObject {
+	var objs []rubyobj.RubyObject
+	dec := rubyobj.NewDecoder(r)
+	for {
+		var rObj rubyobj.RubyObject
+		err := dec.Decode(&rObj)
+		if err == io.EOF {
+			return objs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		objs = append(objs, rObj)
+	}
+}
+
+func TestDuplicateStringsSmallDump(t *testing.T) {
+	objs := decodeFile(t, "testdata/small.json")
+
+	report := rubyobj.NewDuplicateStringReport()
+	strs := make(map[uint64]*rubyobj.RubyObject)
+	for i := range objs {
+		report.Add(&objs[i])
+		if objs[i].Type == rubyobj.String {
+			strs[objs[i].Address] = &objs[i]
+		}
+	}
+
+	// Shared strings are counted with the string of their buffer, if it has
+	// a value.
+	var wantShared uint64
+	for _, str := range strs {
+		if !str.Shared() {
+			continue
+		}
+		buf, ok := strs[str.References[0]]
+		if ok && !buf.Shared() && (buf.Value != "" || buf.Bytesize == 0) {
+			wantShared++
+		}
+	}
+	if wantShared == 0 {
+		t.Fatal("small.json should have shared strings")
+	}
+
+	var gotShared uint64
+	for _, dup := range report.Duplicates(2) {
+		if dup.Value == "" && dup.Bytesize != 0 {
+			t.Errorf("strings without a value were grouped together: %+v", dup)
+		}
+		if dup.Shared > dup.Count {
+			t.Errorf("more shared strings than copies: %+v", dup)
+		}
+		gotShared += dup.Shared
+	}
+	if gotShared != wantShared {
+		t.Errorf("want %d shared strings counted, got %d", wantShared, gotShared)
+	}
+}
+
+func TestDuplicateStringsSharedAndNonASCII(t *testing.T) {
+	// A shared string before its buffer, one whose buffer isn't in the dump,
+	// and non-ASCII strings of the same size, whose values aren't dumped.
+	objs := decodeDump(t, strings.NewReader(`{"address":"0x7fc96c000001", "type":"STRING", "shared":true, "encoding":"UTF-8", "references":["0x7fc96c000003"]}
+{"address":"0x7fc96c000002", "type":"STRING", "shared":true, "encoding":"UTF-8", "references":["0x7fc96c000009"]}
+{"address":"0x7fc96c000003", "type":"STRING", "bytesize":3, "value":"abc", "encoding":"UTF-8"}
+{"address":"0x7fc96c000004", "type":"STRING", "bytesize":3, "value":"abc", "encoding":"UTF-8"}
+{"address":"0x7fc96c000005", "type":"STRING", "bytesize":4, "encoding":"UTF-8"}
+{"address":"0x7fc96c000006", "type":"STRING", "bytesize":4, "encoding":"UTF-8"}
+`))
+
+	report := rubyobj.NewDuplicateStringReport()
+	for i := range objs {
+		report.Add(&objs[i])
+	}
+	dups := report.Duplicates(2)
+	if len(dups) != 1 {
+		t.Fatalf("want 1 duplicate, got %+v", dups)
+	}
+	dup := dups[0]
+	if dup.Value != "abc" || dup.Count != 3 || dup.Shared != 1 || dup.WastedBytes != 3 {
+		t.Errorf("want 3 copies of abc, 1 shared, wasting 3 bytes, got %+v", dup)
+	}
+}
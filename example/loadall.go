@@ -1,14 +1,30 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"embed"
+	"encoding/json"
 	"fmt"
 	"github.com/aybabtme/rubyobj"
 	"github.com/codegangsta/cli"
 	"github.com/dustin/go-humanize"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/peterh/liner"
 	"io"
+	"io/fs"
+	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"text/tabwriter"
 	"time"
 )
 
@@ -28,13 +44,285 @@ var (
 		Action:      loadParallelAction("parallel"),
 		Flags:       []cli.Flag{cli.StringFlag{Name: "filename", Usage: "name of the file to open"}},
 	}
+
+	sitesCommand = cli.Command{
+		Name:        "sites",
+		Usage:       "reports the allocation sites of Ruby heap objects",
+		Description: "Groups objects by the file:line that allocated them. The dump must have been taken with ObjectSpace.trace_object_allocations.",
+		Action:      allocSitesAction("sites"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.BoolFlag{Name: "by-method", Usage: "split sites by method"},
+			cli.BoolFlag{Name: "by-class", Usage: "split sites by class of the objects"},
+			cli.IntFlag{Name: "min-gen", Usage: "ignore objects allocated before this GC generation"},
+			cli.IntFlag{Name: "max-gen", Usage: "ignore objects allocated after this GC generation"},
+			cli.IntFlag{Name: "top", Value: 20, Usage: "how many sites to show, 0 for all"},
+			whereFlag,
+		},
+	}
+
+	ageCommand = cli.Command{
+		Name:        "age",
+		Usage:       "reports the age of Ruby heap objects",
+		Description: "Buckets objects by the GC generation that allocated them, and lists the young objects held by old ones.",
+		Action:      ageAction("age"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.IntFlag{Name: "bucket", Value: 1, Usage: "how many generations to group together"},
+			cli.IntFlag{Name: "top", Value: 20, Usage: "how many classes of young objects to show, 0 for all"},
+		},
+	}
+
+	stringsCommand = cli.Command{
+		Name:        "strings",
+		Usage:       "reports the duplicated strings of a Ruby heap",
+		Description: "Finds the STRING objects sharing the same content, ordered by the memory wasted by the copies.",
+		Action:      dupStringsAction("strings"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.IntFlag{Name: "min-count", Value: 2, Usage: "ignore strings with fewer copies"},
+			cli.IntFlag{Name: "top", Value: 20, Usage: "how many strings to show, 0 for all"},
+			whereFlag,
+		},
+	}
+
+	collectionsCommand = cli.Command{
+		Name:        "collections",
+		Usage:       "reports wasteful arrays, hashes and structs of a Ruby heap",
+		Description: "Finds empty-but-allocated and oversized collections, grouped by class and allocation site, and the biggest collections.",
+		Action:      collectionsAction("collections"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.IntFlag{Name: "top", Value: 20, Usage: "how many groups and collections to show"},
+			whereFlag,
+		},
+	}
+
+	cyclesCommand = cli.Command{
+		Name:        "cycles",
+		Usage:       "finds the reference cycles of a Ruby heap",
+		Description: "Reports the strongly connected components of the reference graph, with the references entering them from outside.",
+		Action:      cyclesAction("cycles"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.IntFlag{Name: "top", Value: 10, Usage: "how many components to show, 0 for all"},
+		},
+	}
+
+	queryCommand = cli.Command{
+		Name:        "query",
+		Usage:       "runs graph queries on the heap",
+		Description: "Loads the heap once and runs the query given with --query, or reads queries from stdin, one per line. Queries are stages separated by |, like: where class_name == \"Foo\" | out 3 | where type == STRING",
+		Action:      queryAction("query"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.StringFlag{Name: "query", Usage: "query to run, instead of reading them from stdin"},
+			cli.IntFlag{Name: "top", Value: 20, Usage: "how many objects of the result to show, 0 for all"},
+		},
+	}
+
+	replCommand = cli.Command{
+		Name:        "repl",
+		Usage:       "explores the heap interactively",
+		Description: "Loads the heap once and reads commands like show, refs, referrers, path, histogram, find and strings. Addresses and class names complete with tab, and long outputs go through $PAGER.",
+		Action:      replAction("repl"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.StringFlag{Name: "history", Value: filepath.Join(os.Getenv("HOME"), ".loadall_history"), Usage: "file to keep the history of commands in"},
+			cli.IntFlag{Name: "top", Value: 100, Usage: "how many objects or classes to list, 0 for all"},
+		},
+	}
+
+	serveCommand = cli.Command{
+		Name:        "serve",
+		Usage:       "browses the heap in a web browser",
+		Description: "Loads the heap and serves a web UI to browse it: class histogram, objects with their references and referrers, retention paths, search by address or value, and a diff against a baseline heap. The REST API of the heap is served under /api/heap/.",
+		Action:      serveAction("serve"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.StringFlag{Name: "baseline", Usage: "name of an earlier dump to diff against"},
+			cli.StringFlag{Name: "listen", Value: "localhost:8080", Usage: "address to serve on"},
+		},
+	}
+
+	indexCommand = cli.Command{
+		Name:        "index",
+		Usage:       "builds the sidecar index of a dump and looks objects up in it",
+		Description: "Builds <filename>.idx if it's missing or older than the dump, then answers lookups from it without decoding the dump.",
+		Action:      indexAction("index"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.StringFlag{Name: "address", Usage: "address of an object whose referrers to list"},
+			cli.StringFlag{Name: "class", Usage: "name of a class whose instances to list"},
+			cli.IntFlag{Name: "top", Value: 20, Usage: "how many addresses to list, 0 for all"},
+		},
+	}
+
+	lookupCommand = cli.Command{
+		Name:        "lookup",
+		Usage:       "prints single objects of a dump",
+		Description: "Finds the objects at the given addresses with the sidecar index of the dump, building it if needed, and prints them as JSON lines without decoding the rest of the dump.",
+		Action:      lookupAction("lookup"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.BoolFlag{Name: "no-index", Usage: "scan the dump for offsets instead of using the sidecar index"},
+		},
+	}
+
+	dotCommand = cli.Command{
+		Name:        "dot",
+		Usage:       "renders the neighbourhood of objects as a Graphviz graph",
+		Description: "Starting from an object address, from all the instances of a class or from the objects matching a filter, follows references in both directions and writes a DOT digraph.",
+		Action:      dotAction("dot"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.StringFlag{Name: "address", Usage: "address of the object to start from"},
+			cli.StringFlag{Name: "class", Usage: "name of the class whose instances to start from"},
+			whereFlag,
+			cli.IntFlag{Name: "depth", Value: 2, Usage: "how many references to follow"},
+			cli.IntFlag{Name: "max-nodes", Value: 200, Usage: "maximum number of objects to render, 0 for no limit"},
+			cli.IntFlag{Name: "max-edges", Value: 1000, Usage: "maximum number of references to render, 0 for no limit"},
+			cli.StringFlag{Name: "output", Usage: "file to write the graph to, defaults to stdout"},
+		},
+	}
+
+	graphCommand = cli.Command{
+		Name:        "graph",
+		Usage:       "exports the heap as a GraphML or GEXF graph",
+		Description: "Writes objects as nodes, with all their fields as attributes, and references as edges, for tools like Gephi.",
+		Action:      graphAction("graph"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.StringFlag{Name: "format", Value: "graphml", Usage: "graphml or gexf"},
+			cli.StringFlag{Name: "type", Usage: "only export objects of this type, like STRING"},
+			cli.StringFlag{Name: "class", Usage: "only export instances of this class"},
+			whereFlag,
+			cli.StringFlag{Name: "output", Usage: "file to write the graph to, defaults to stdout"},
+		},
+	}
+
+	pprofCommand = cli.Command{
+		Name:        "pprof",
+		Usage:       "converts the heap to a pprof profile",
+		Description: "Writes a profile.proto of the live objects by allocation site, called from the sites of their dominators, with count, memsize and retained size values, for `go tool pprof`.",
+		Action:      pprofAction("pprof"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.StringFlag{Name: "output", Usage: "file to write the profile to, defaults to stdout"},
+		},
+	}
+
+	exportCommand = cli.Command{
+		Name:        "export",
+		Usage:       "exports objects and references as tables",
+		Description: "Writes an objects table, one column per field, and an edges table of references (from, to, ordinal), as CSV, TSV or Apache Parquet files.",
+		Action:      exportAction("export"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.StringFlag{Name: "format", Value: "csv", Usage: "csv, tsv or parquet"},
+			cli.StringFlag{Name: "objects", Usage: "file to write the objects table to, defaults to objects.<format>"},
+			cli.StringFlag{Name: "edges", Usage: "file to write the edges table to, defaults to edges.<format>"},
+			cli.StringFlag{Name: "columns", Usage: "comma separated columns of the objects table, defaults to all of: " + strings.Join(rubyobj.ObjectColumns(), ",")},
+			whereFlag,
+		},
+	}
+
+	sqliteCommand = cli.Command{
+		Name:        "sqlite",
+		Usage:       "exports the heap to an SQLite database",
+		Description: "Writes the objects, edges, roots and classes tables to a new SQLite database, indexed on addresses, classes and allocation sites.",
+		Action:      sqliteAction("sqlite"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.StringFlag{Name: "output", Value: "heap.db", Usage: "database file to create"},
+			whereFlag,
+		},
+	}
+
+	filterCommand = cli.Command{
+		Name:        "filter",
+		Usage:       "writes the objects matching a filter as a new dump",
+		Description: "Copies the objects matching --where to a new dump, in order, keeping the members loadall doesn't know about, and only the --fields given if any.",
+		Action:      filterAction("filter"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.StringFlag{Name: "output", Usage: "file to write the dump to, defaults to stdout"},
+			cli.StringFlag{Name: "fields", Usage: "comma separated members to keep, like memsize,references; type, address and root are always kept"},
+			whereFlag,
+		},
+	}
+
+	redactCommand = cli.Command{
+		Name:        "redact",
+		Usage:       "writes a copy of the dump with string values redacted",
+		Description: "Replaces the value of the strings matching any of the --class, --file or --value rules, or of all strings if there are none, with a keyed hash or a placeholder of the same length. Sizes, addresses and references are kept.",
+		Action:      redactAction("redact"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.StringFlag{Name: "output", Usage: "file to write the dump to, defaults to stdout"},
+			cli.StringFlag{Name: "mode", Value: "hash", Usage: "hash or placeholder"},
+			cli.StringFlag{Name: "key", EnvVar: "LOADALL_REDACT_KEY", Usage: "secret key of the hashes"},
+			cli.StringSliceFlag{Name: "class", Value: &cli.StringSlice{}, Usage: "redact strings of this class"},
+			cli.StringSliceFlag{Name: "file", Value: &cli.StringSlice{}, Usage: "redact strings allocated in files matching this regexp"},
+			cli.StringSliceFlag{Name: "value", Value: &cli.StringSlice{}, Usage: "redact strings whose value matches this regexp"},
+		},
+	}
+
+	anonymizeCommand = cli.Command{
+		Name:        "anonymize",
+		Usage:       "writes a copy of the dump with addresses remapped",
+		Description: "Remaps all the addresses consistently, so the graph is unchanged, rewrites allocation files by --file-prefix rules and optionally scrubs the names of classes and modules. String values are kept, see redact.",
+		Action:      anonymizeAction("anonymize"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.StringFlag{Name: "output", Usage: "file to write the dump to, defaults to stdout"},
+			cli.StringSliceFlag{Name: "file-prefix", Value: &cli.StringSlice{}, Usage: "rewrite files starting with a prefix, like /home/deploy/app/=app/"},
+			cli.BoolFlag{Name: "scrub-names", Usage: "replace the names of classes and modules with placeholders"},
+		},
+	}
+
+	validateCommand = cli.Command{
+		Name:        "validate",
+		Usage:       "checks that the dump is complete and consistent",
+		Description: "Looks for undecodable or truncated lines, duplicate addresses, dangling references, missing classes and ROOT records, and objects that can't be, printing how many of each with samples. Exits with status 1 if anything is found.",
+		Action:      validateAction("validate"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.IntFlag{Name: "samples", Value: 5, Usage: "how many issues of each kind to show"},
+		},
+	}
+
+	statsCommand = cli.Command{
+		Name:        "stats",
+		Usage:       "sums up how big the heap is and what it's made of",
+		Description: "Prints totals by type, memsize, reference degree and generation percentiles, flag counts and the size of each root category.",
+		Action:      statsAction("stats"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "filename", Usage: "name of the file to open"},
+			cli.BoolFlag{Name: "json", Usage: "print the stats as JSON"},
+		},
+	}
+
+	timeseriesCommand = cli.Command{
+		Name:        "timeseries",
+		Usage:       "follows classes and allocation sites across dumps",
+		Description: "Takes dumps of the same process as arguments, oldest first, and writes the count and memsize of each class and allocation site in each dump, flagging those that only grew as leak candidates. The text format only shows leak candidates.",
+		Action:      timeseriesAction("timeseries"),
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "format", Value: "text", Usage: "text, csv or json"},
+			cli.StringFlag{Name: "output", Usage: "file to write to, defaults to stdout"},
+			cli.IntFlag{Name: "top", Value: 20, Usage: "how many leak candidates of each kind to show in text, 0 for all"},
+		},
+	}
 )
 
+var whereFlag = cli.StringFlag{Name: "where", Usage: "only consider the objects matching this filter, like 'type == STRING && memsize > 1024'"}
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "loadruby"
 	app.Usage = "load a ruby heap dump file into memory"
-	app.Commands = []cli.Command{trivialCommand, parallelCommand}
+	app.Commands = []cli.Command{trivialCommand, parallelCommand, sitesCommand, ageCommand, stringsCommand, collectionsCommand, cyclesCommand, queryCommand, replCommand, serveCommand, indexCommand, lookupCommand, dotCommand, graphCommand, pprofCommand, exportCommand, sqliteCommand, filterCommand, redactCommand, anonymizeCommand, validateCommand, statsCommand, timeseriesCommand}
 
 	app.Run(os.Args)
 }
@@ -97,6 +385,1412 @@ func loadParallelAction(command string) func(*cli.Context) {
 	}
 }
 
+// Reports
+
+func allocSitesAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		report := rubyobj.NewAllocSiteReport(rubyobj.AllocSiteOptions{
+			ByMethod:      c.Bool("by-method"),
+			ByClass:       c.Bool("by-class"),
+			MinGeneration: uint64(c.Int("min-gen")),
+			MaxGeneration: uint64(c.Int("max-gen")),
+			Where:         wherePredicate(c, command, nil),
+		})
+
+		eachObject(c, command, report.Add)
+
+		sites := report.Sites()
+		if top := c.Int("top"); top > 0 && top < len(sites) {
+			sites = sites[:top]
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "count\tmemsize\tsite\n")
+		for _, site := range sites {
+			fmt.Fprintf(tw, "%d\t%s\t%v\n", site.Count, humanize.Bytes(site.Memsize), site.AllocSite)
+		}
+		tw.Flush()
+
+		if report.Untraced != 0 {
+			fmt.Printf("%d objects without allocation information\n", report.Untraced)
+		}
+	}
+}
+
+func ageAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		heap := loadHeap(c, command)
+		report := rubyobj.NewAgeReport(heap, uint64(c.Int("bucket")))
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "generations\tcount\tmemsize\told\n")
+		for _, gen := range report.Generations {
+			fmt.Fprintf(tw, "%d-%d\t%d\t%s\t%d\n", gen.First, gen.Last, gen.Count, humanize.Bytes(gen.Memsize), gen.Old)
+		}
+		tw.Flush()
+		if report.Untraced != 0 {
+			fmt.Printf("%d objects without generation information\n", report.Untraced)
+		}
+
+		young := report.YoungRetained
+		if top := c.Int("top"); top > 0 && top < len(young) {
+			young = young[:top]
+		}
+		fmt.Printf("\nyoung objects retained by old objects:\n")
+		tw = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "count\tmemsize\twb-unprotected\tclass\tsamples\n")
+		for _, yr := range young {
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\n", yr.Count, humanize.Bytes(yr.Memsize), yr.Unprotected, yr.Class, formatAddrs(yr.Samples))
+		}
+		tw.Flush()
+	}
+}
+
+func dupStringsAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		report := rubyobj.NewDuplicateStringReport()
+
+		eachMatchingObject(c, command, report.Add)
+
+		dups := report.Duplicates(uint64(c.Int("min-count")))
+		if top := c.Int("top"); top > 0 && top < len(dups) {
+			dups = dups[:top]
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "count\twasted\tfrozen\tfstring\tvalue\tsites\n")
+		for _, dup := range dups {
+			sites := make([]string, len(dup.Sites))
+			for i, site := range dup.Sites {
+				sites[i] = fmt.Sprintf("%v (%d)", site.AllocSite, site.Count)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%v\t%s\t%s\n",
+				dup.Count, humanize.Bytes(dup.WastedBytes), dup.Frozen, dup.Fstring,
+				preview(dup.Value, 40), strings.Join(sites, ", "))
+		}
+		tw.Flush()
+	}
+}
+
+func collectionsAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		top := c.Int("top")
+		report := rubyobj.NewCollectionReport(top)
+		report.Where = wherePredicate(c, command, nil)
+
+		eachObject(c, command, report.Add)
+
+		groups := report.Groups()
+		if top > 0 && top < len(groups) {
+			groups = groups[:top]
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "type\tcount\tempty\tempty memsize\tmemsize\tcapa/len\tclass\tsite\n")
+		for _, g := range groups {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%.2f\t%s\t%v\n",
+				g.Type.Name(), g.Count, g.Empty, humanize.Bytes(g.EmptyMemsize),
+				humanize.Bytes(g.Memsize), g.WasteRatio(), g.Class, g.Site)
+		}
+		tw.Flush()
+
+		fmt.Printf("\nbiggest collections:\n")
+		tw = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "address\ttype\telements\tcapacity\tmemsize\tclass\tsite\n")
+		for _, coll := range report.Biggest() {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%v\n",
+				rubyobj.FormatAddress(coll.Address), coll.Type.Name(), coll.Elements, coll.Capacity,
+				humanize.Bytes(coll.Memsize), coll.Class, coll.Site)
+		}
+		tw.Flush()
+	}
+}
+
+func cyclesAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		heap := loadHeap(c, command)
+		comps := rubyobj.StronglyConnected(heap)
+		fmt.Printf("%d cycles\n", len(comps))
+		if top := c.Int("top"); top > 0 && top < len(comps) {
+			comps = comps[:top]
+		}
+
+		for i, comp := range comps {
+			fmt.Printf("\n#%d: %d objects, %s\n", i+1, len(comp.Members), humanize.Bytes(comp.Memsize))
+			tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			for _, cc := range comp.Classes {
+				fmt.Fprintf(tw, "  %d\t%s\t%s\n", cc.Count, humanize.Bytes(cc.Memsize), cc.Class)
+			}
+			tw.Flush()
+			fmt.Printf("  %d entering references\n", len(comp.Entries))
+			for j, edge := range comp.Entries {
+				if j == 5 {
+					fmt.Printf("  ...\n")
+					break
+				}
+				from := "ROOT"
+				if edge.From != 0 {
+					from = rubyobj.FormatAddress(edge.From)
+				}
+				fmt.Printf("  %s -> %s\n", from, rubyobj.FormatAddress(edge.To))
+			}
+		}
+	}
+}
+
+func queryAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		heap := loadHeap(c, command)
+		engine := rubyobj.NewQueryEngine(heap)
+		top := c.Int("top")
+
+		if c.IsSet("query") {
+			fatal(runQuery(os.Stdout, engine, heap, c.String("query"), top))
+			return
+		}
+
+		in := bufio.NewScanner(os.Stdin)
+		for {
+			fmt.Print("query> ")
+			if !in.Scan() {
+				fmt.Println()
+				break
+			}
+			switch line := strings.TrimSpace(in.Text()); line {
+			case "":
+			case "exit", "quit":
+				return
+			default:
+				if err := runQuery(os.Stdout, engine, heap, line, top); err != nil {
+					fmt.Printf("error: %v\n", err)
+				}
+			}
+		}
+		fatal(in.Err())
+	}
+}
+
+// runQuery prints the top objects selected by expr to w.  The error of an
+// invalid query is returned for the caller to print where w goes.
+func runQuery(w io.Writer, engine *rubyobj.QueryEngine, heap *rubyobj.Heap, expr string, top int) error {
+	start := time.Now()
+	addrs, err := engine.Query(expr)
+	if err != nil {
+		return err
+	}
+
+	var memsize uint64
+	for _, addr := range addrs {
+		rObj, _ := heap.Lookup(addr)
+		memsize += rObj.Memsize
+	}
+	fmt.Fprintf(w, "%d objects, %s, in %v\n", len(addrs), humanize.Bytes(memsize), time.Since(start))
+	printObjects(w, heap, addrs, top)
+	return nil
+}
+
+// printObjects prints the top objects at addrs, one per line.
+func printObjects(w io.Writer, heap *rubyobj.Heap, addrs []uint64, top int) {
+	shown := addrs
+	if top > 0 && top < len(shown) {
+		shown = shown[:top]
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, addr := range shown {
+		rObj, ok := heap.Lookup(addr)
+		if !ok {
+			fmt.Fprintf(tw, "%s\t(not in heap)\n", rubyobj.FormatAddress(addr))
+			continue
+		}
+		var value string
+		if v := formatValue(rObj); v != "" {
+			value = preview(v, 40)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			rubyobj.FormatAddress(addr), rObj.Type.Name(), heap.ClassName(rObj),
+			humanize.Bytes(rObj.Memsize), value)
+	}
+	tw.Flush()
+	if len(shown) < len(addrs) {
+		fmt.Fprintf(w, "... %d more\n", len(addrs)-len(shown))
+	}
+}
+
+// REPL
+
+const replHelp = `commands:
+  show [addr]          describes the object at addr, or the last one shown
+  refs [addr]          lists the objects referenced by the object
+  referrers [addr]     lists the objects referencing the object
+  path [addr]          shows a shortest chain of references from a root
+  histogram [filter]   counts the objects matching filter by class
+  find <filter>        lists the objects matching filter
+  strings <regexp>     lists the strings whose value matches regexp
+  query <query>        runs a graph query, see the query command
+  help                 shows this help
+  quit                 exits
+`
+
+// replCommands are sorted, for completion.
+var replCommands = []string{"find", "help", "histogram", "path", "query", "quit", "referrers", "refs", "show", "strings"}
+
+// repl holds the state of a REPL session.
+type repl struct {
+	heap   *rubyobj.Heap
+	engine *rubyobj.QueryEngine
+	top    int
+
+	// current is the address of the last object shown.
+	current uint64
+
+	// addrs and classes are sorted, for completion.
+	addrs   []string
+	classes []string
+}
+
+func replAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		heap := loadHeap(c, command)
+		r := &repl{
+			heap:    heap,
+			engine:  rubyobj.NewQueryEngine(heap),
+			top:     c.Int("top"),
+			classes: heap.ClassNames(),
+		}
+		objects := heap.Objects()
+		r.addrs = make([]string, len(objects))
+		for i := range objects {
+			r.addrs[i] = rubyobj.FormatAddress(objects[i].Address)
+		}
+		sort.Strings(r.addrs)
+
+		line := liner.NewLiner()
+		defer line.Close()
+		line.SetCtrlCAborts(true)
+		line.SetWordCompleter(r.complete)
+
+		history := c.String("history")
+		if fr, err := os.Open(history); err == nil {
+			line.ReadHistory(fr)
+			fr.Close()
+		}
+		defer func() {
+			if fw, err := os.Create(history); err == nil {
+				line.WriteHistory(fw)
+				fw.Close()
+			}
+		}()
+
+		fmt.Println(`type "help" for the list of commands`)
+		for {
+			input, err := line.Prompt("heap> ")
+			if err == liner.ErrPromptAborted {
+				continue
+			}
+			if err == io.EOF {
+				fmt.Println()
+				return
+			}
+			fatal(err)
+
+			input = strings.TrimSpace(input)
+			if input == "" {
+				continue
+			}
+			line.AppendHistory(input)
+			if input == "quit" || input == "exit" {
+				return
+			}
+
+			// Errors go with the output, so they're not lost behind the pager.
+			var buf bytes.Buffer
+			if err := r.run(&buf, input); err != nil {
+				fmt.Fprintf(&buf, "error: %v\n", err)
+			}
+			page(buf.Bytes())
+		}
+	}
+}
+
+// run executes a line of input, writing its output to w.
+func (r *repl) run(w io.Writer, input string) error {
+	cmd, arg := input, ""
+	if i := strings.IndexAny(input, " \t"); i >= 0 {
+		cmd, arg = input[:i], strings.TrimSpace(input[i:])
+	}
+
+	switch cmd {
+	case "help":
+		fmt.Fprint(w, replHelp)
+		return nil
+
+	case "show", "refs", "referrers", "path":
+		rObj, err := r.object(arg)
+		if err != nil {
+			return err
+		}
+		switch cmd {
+		case "show":
+			r.show(w, rObj)
+		case "refs":
+			fmt.Fprintf(w, "%d references\n", len(rObj.References))
+			printObjects(w, r.heap, rObj.References, r.top)
+		case "referrers":
+			referrers := r.heap.Referrers(rObj.Address)
+			fmt.Fprintf(w, "%d referrers\n", len(referrers))
+			printObjects(w, r.heap, referrers, r.top)
+			if cats := r.heap.RootCategories(rObj.Address); len(cats) != 0 {
+				fmt.Fprintf(w, "also held by ROOT %s\n", strings.Join(cats, ", "))
+			}
+		case "path":
+			path := r.heap.PathFromRoot(rObj.Address)
+			if path == nil {
+				fmt.Fprintf(w, "not reachable from the roots\n")
+				return nil
+			}
+			fmt.Fprintf(w, "ROOT %s\n", strings.Join(r.heap.RootCategories(path[0]), ", "))
+			printObjects(w, r.heap, path, 0)
+		}
+		return nil
+
+	case "histogram":
+		var addrs []uint64
+		if arg == "" {
+			addrs, _ = r.engine.Query("all")
+		} else {
+			var err error
+			if addrs, err = r.engine.Query("where " + arg); err != nil {
+				return err
+			}
+		}
+		hist := r.heap.Histogram(addrs)
+		if r.top > 0 && r.top < len(hist) {
+			hist = hist[:r.top]
+		}
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "count\tmemsize\tclass\n")
+		for _, cc := range hist {
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", cc.Count, humanize.Bytes(cc.Memsize), cc.Class)
+		}
+		tw.Flush()
+		return nil
+
+	case "find":
+		if arg == "" {
+			return fmt.Errorf("find needs a filter, like: find type == STRING && memsize > 1024")
+		}
+		return runQuery(w, r.engine, r.heap, "where "+arg, r.top)
+
+	case "strings":
+		if arg == "" {
+			return fmt.Errorf("strings needs a regexp")
+		}
+		return runQuery(w, r.engine, r.heap, "where type == STRING && value =~ "+strconv.Quote(arg), r.top)
+
+	case "query":
+		return runQuery(w, r.engine, r.heap, arg, r.top)
+	}
+	return fmt.Errorf("unknown command %q, try help", cmd)
+}
+
+// object finds the object at the address arg, or the current object if arg
+// is empty, and makes it the current object.
+func (r *repl) object(arg string) (*rubyobj.RubyObject, error) {
+	addr := r.current
+	if arg != "" {
+		var err error
+		if addr, err = rubyobj.ParseAddress(arg); err != nil {
+			return nil, err
+		}
+	} else if addr == 0 {
+		return nil, fmt.Errorf("no current object, give an address")
+	}
+	rObj, ok := r.heap.Lookup(addr)
+	if !ok {
+		return nil, fmt.Errorf("no object at address %s", rubyobj.FormatAddress(addr))
+	}
+	r.current = addr
+	return rObj, nil
+}
+
+func (r *repl) show(w io.Writer, rObj *rubyobj.RubyObject) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	field := func(name string, value interface{}) {
+		fmt.Fprintf(tw, "%s\t%v\n", name, value)
+	}
+	field("address", rubyobj.FormatAddress(rObj.Address))
+	field("type", rObj.Type.Name())
+	if rObj.Class != 0 {
+		field("class", fmt.Sprintf("%s (%s)", r.heap.ClassName(rObj), rubyobj.FormatAddress(rObj.Class)))
+	}
+	if rObj.Name != "" {
+		field("name", rObj.Name)
+	}
+	if v := formatValue(rObj); v != "" {
+		field("value", preview(v, 200))
+	}
+	if rObj.Encoding != "" {
+		field("encoding", rObj.Encoding)
+	}
+	if rObj.File != "" {
+		field("allocated", fmt.Sprintf("%s:%d %s, generation %d", rObj.File, rObj.Line, rObj.Method, rObj.Generation))
+	}
+	field("memsize", humanize.Bytes(rObj.Memsize))
+	for _, f := range []struct {
+		name  string
+		value uint64
+	}{
+		{"bytesize", rObj.Bytesize},
+		{"length", rObj.Length},
+		{"capacity", rObj.Capacity},
+		{"size", rObj.Size},
+		{"ivars", rObj.Ivars},
+	} {
+		if f.value != 0 {
+			field(f.name, f.value)
+		}
+	}
+	var flags []string
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"frozen", rObj.Frozen()},
+		{"embedded", rObj.Embedded()},
+		{"broken", rObj.Broken()},
+		{"fstring", rObj.Fstring()},
+		{"shared", rObj.Shared()},
+		{"wb_protected", rObj.GcWbProtected()},
+		{"old", rObj.GcOld()},
+		{"marked", rObj.GcMarked()},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	if len(flags) != 0 {
+		field("flags", strings.Join(flags, " "))
+	}
+	field("references", len(rObj.References))
+	field("referrers", len(r.heap.Referrers(rObj.Address)))
+	if cats := r.heap.RootCategories(rObj.Address); len(cats) != 0 {
+		field("roots", strings.Join(cats, ", "))
+	}
+	tw.Flush()
+}
+
+// complete completes the word under the cursor: command names first, then
+// addresses, or class names.
+func (r *repl) complete(line string, pos int) (head string, completions []string, tail string) {
+	const maxCompletions = 100
+
+	// pos counts runes, not bytes.
+	runes := []rune(line)
+	start := pos
+	for start > 0 && !strings.ContainsRune(" \t\"", runes[start-1]) {
+		start--
+	}
+	head, word, tail := string(runes[:start]), string(runes[start:pos]), string(runes[pos:])
+
+	var candidates []string
+	switch {
+	case strings.TrimSpace(head) == "":
+		candidates = replCommands
+	case strings.HasPrefix(word, "0x"):
+		candidates = r.addrs
+	default:
+		candidates = r.classes
+	}
+	for i := sort.SearchStrings(candidates, word); i < len(candidates) && len(completions) < maxCompletions; i++ {
+		if !strings.HasPrefix(candidates[i], word) {
+			break
+		}
+		completions = append(completions, candidates[i])
+	}
+	return head, completions, tail
+}
+
+// page shows out through $PAGER, less by default, when writing to a terminal.
+func page(out []byte) {
+	fi, err := os.Stdout.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		os.Stdout.Write(out)
+		return
+	}
+	pager := strings.Fields(os.Getenv("PAGER"))
+	if len(pager) == 0 {
+		pager = []string{"less", "-FRX"}
+	}
+	cmd := exec.Command(pager[0], pager[1:]...)
+	cmd.Stdin = bytes.NewReader(out)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		os.Stdout.Write(out)
+	}
+}
+
+// Web UI
+
+//go:embed ui
+var uiFiles embed.FS
+
+// maxListed caps the objects listed by the web UI in a single response.
+const maxListed = 500
+
+// objectLink is an object as listed by the web UI.
+type objectLink struct {
+	Address string `json:"address"`
+	Type    string `json:"type"`
+	Class   string `json:"class"`
+	Memsize uint64 `json:"memsize"`
+	Value   string `json:"value,omitempty"`
+}
+
+// objectView is an object as detailed by the web UI.
+type objectView struct {
+	objectLink
+	ClassAddress string       `json:"class_address,omitempty"`
+	Name         string       `json:"name,omitempty"`
+	Encoding     string       `json:"encoding,omitempty"`
+	File         string       `json:"file,omitempty"`
+	Line         uint64       `json:"line,omitempty"`
+	Method       string       `json:"method,omitempty"`
+	Generation   uint64       `json:"generation,omitempty"`
+	Bytesize     uint64       `json:"bytesize,omitempty"`
+	Length       uint64       `json:"length,omitempty"`
+	Capacity     uint64       `json:"capacity,omitempty"`
+	Size         uint64       `json:"size,omitempty"`
+	Ivars        uint64       `json:"ivars,omitempty"`
+	Retained     uint64       `json:"retained"`
+	Flags        []string     `json:"flags"`
+	Roots        []string     `json:"roots"`
+	References   []objectLink `json:"references"`
+	Referrers    []objectLink `json:"referrers"`
+}
+
+// heapServer serves the web UI over a heap, and optionally a baseline heap
+// to diff it against.
+type heapServer struct {
+	heap     *rubyobj.Heap
+	baseline *rubyobj.Heap
+	engine   *rubyobj.QueryEngine
+}
+
+func serveAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		srv := &heapServer{heap: loadHeap(c, command)}
+		srv.engine = rubyobj.NewQueryEngine(srv.heap)
+		if c.IsSet("baseline") {
+			srv.baseline = readHeap(openFile(c.String("baseline")))
+		}
+
+		ui, err := fs.Sub(uiFiles, "ui")
+		fatal(err)
+
+		mux := http.NewServeMux()
+		mux.Handle("/", http.FileServer(http.FS(ui)))
+		mux.HandleFunc("/api/histogram", srv.histogram)
+		mux.HandleFunc("/api/object", srv.object)
+		mux.HandleFunc("/api/path", srv.path)
+		mux.HandleFunc("/api/search", srv.search)
+		mux.HandleFunc("/api/diff", srv.diff)
+		mux.Handle("/api/heap/", http.StripPrefix("/api/heap", rubyobj.NewHandler(srv.heap)))
+
+		fmt.Printf("serving on http://%s/\n", c.String("listen"))
+		fatal(http.ListenAndServe(c.String("listen"), mux))
+	}
+}
+
+func (s *heapServer) link(addr uint64) objectLink {
+	rObj, ok := s.heap.Lookup(addr)
+	if !ok {
+		return objectLink{Address: rubyobj.FormatAddress(addr), Type: "(not in heap)"}
+	}
+	return objectLink{
+		Address: rubyobj.FormatAddress(addr),
+		Type:    rObj.Type.Name(),
+		Class:   s.heap.ClassName(rObj),
+		Memsize: rObj.Memsize,
+		Value:   truncate(formatValue(rObj), 80),
+	}
+}
+
+func (s *heapServer) links(addrs []uint64) []objectLink {
+	if len(addrs) > maxListed {
+		addrs = addrs[:maxListed]
+	}
+	links := make([]objectLink, len(addrs))
+	for i, addr := range addrs {
+		links[i] = s.link(addr)
+	}
+	return links
+}
+
+// lookup finds the object at the address in the addr parameter, replying
+// with an error if there is none.
+func (s *heapServer) lookup(w http.ResponseWriter, r *http.Request) (*rubyobj.RubyObject, bool) {
+	addr, err := rubyobj.ParseAddress(r.FormValue("addr"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	rObj, ok := s.heap.Lookup(addr)
+	if !ok {
+		http.Error(w, "no object at address "+rubyobj.FormatAddress(addr), http.StatusNotFound)
+		return nil, false
+	}
+	return rObj, true
+}
+
+func (s *heapServer) histogram(w http.ResponseWriter, r *http.Request) {
+	query := "all"
+	if where := r.FormValue("where"); where != "" {
+		query = "where " + where
+	}
+	addrs, err := s.engine.Query(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	type classCount struct {
+		Class   string `json:"class"`
+		Count   uint64 `json:"count"`
+		Memsize uint64 `json:"memsize"`
+	}
+	hist := s.heap.Histogram(addrs)
+	classes := make([]classCount, len(hist))
+	for i, cc := range hist {
+		classes[i] = classCount{cc.Class, cc.Count, cc.Memsize}
+	}
+	writeJSON(w, classes)
+}
+
+func (s *heapServer) object(w http.ResponseWriter, r *http.Request) {
+	rObj, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	view := objectView{
+		objectLink: s.link(rObj.Address),
+		Name:       rObj.Name,
+		Encoding:   rObj.Encoding,
+		File:       rObj.File,
+		Line:       rObj.Line,
+		Method:     rObj.Method,
+		Generation: rObj.Generation,
+		Bytesize:   rObj.Bytesize,
+		Length:     rObj.Length,
+		Capacity:   rObj.Capacity,
+		Size:       rObj.Size,
+		Ivars:      rObj.Ivars,
+		Retained:   s.engine.DominatorTree().RetainedSize(rObj.Address),
+		Flags:      []string{},
+		Roots:      s.heap.RootCategories(rObj.Address),
+		References: s.links(rObj.References),
+		Referrers:  s.links(s.heap.Referrers(rObj.Address)),
+	}
+	view.Value = formatValue(rObj)
+	if rObj.Class != 0 {
+		view.ClassAddress = rubyobj.FormatAddress(rObj.Class)
+	}
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"frozen", rObj.Frozen()},
+		{"embedded", rObj.Embedded()},
+		{"broken", rObj.Broken()},
+		{"fstring", rObj.Fstring()},
+		{"shared", rObj.Shared()},
+		{"wb_protected", rObj.GcWbProtected()},
+		{"old", rObj.GcOld()},
+		{"marked", rObj.GcMarked()},
+	} {
+		if f.set {
+			view.Flags = append(view.Flags, f.name)
+		}
+	}
+	writeJSON(w, view)
+}
+
+func (s *heapServer) path(w http.ResponseWriter, r *http.Request) {
+	rObj, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	path := s.heap.PathFromRoot(rObj.Address)
+	var roots []string
+	if path != nil {
+		roots = s.heap.RootCategories(path[0])
+	}
+	writeJSON(w, struct {
+		Roots []string     `json:"roots"`
+		Path  []objectLink `json:"path"`
+	}{roots, s.links(path)})
+}
+
+// search finds the object at an address, or else the strings whose value
+// matches a regexp.
+func (s *heapServer) search(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.FormValue("q"))
+	if addr, err := rubyobj.ParseAddress(q); err == nil {
+		if _, ok := s.heap.Lookup(addr); ok {
+			writeJSON(w, []objectLink{s.link(addr)})
+			return
+		}
+	}
+	addrs, err := s.engine.Query("where type == STRING && value =~ " + strconv.Quote(q))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, s.links(addrs))
+}
+
+func (s *heapServer) diff(w http.ResponseWriter, r *http.Request) {
+	if s.baseline == nil {
+		http.Error(w, "no baseline heap, start the server with --baseline", http.StatusNotFound)
+		return
+	}
+	type classDiff struct {
+		Class        string `json:"class"`
+		Count        uint64 `json:"count"`
+		Memsize      uint64 `json:"memsize"`
+		CountDelta   int64  `json:"count_delta"`
+		MemsizeDelta int64  `json:"memsize_delta"`
+	}
+	diffs := rubyobj.DiffHeaps(s.baseline, s.heap)
+	classes := make([]classDiff, 0, len(diffs))
+	for _, d := range diffs {
+		if d.CountDelta() == 0 && d.MemsizeDelta() == 0 {
+			continue
+		}
+		classes = append(classes, classDiff{d.Class, d.After.Count, d.After.Memsize, d.CountDelta(), d.MemsizeDelta()})
+	}
+	added := rubyobj.AddedObjects(s.baseline, s.heap)
+	writeJSON(w, struct {
+		Classes    []classDiff  `json:"classes"`
+		AddedCount int          `json:"added_count"`
+		Added      []objectLink `json:"added"`
+	}{classes, len(added), s.links(added)})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	}
+}
+
+// Index
+
+func indexAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		if !c.IsSet("filename") {
+			cli.ShowCommandHelp(c, command)
+			os.Exit(1)
+		}
+		start := time.Now()
+		idx, err := rubyobj.OpenIndex(c.String("filename"), uint(runtime.NumCPU()))
+		if idx == nil {
+			fatal(err)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}
+		defer idx.Close()
+		fmt.Printf("index of %d objects opened in %v\n", idx.Len(), time.Since(start))
+
+		top := c.Int("top")
+		list := func(addrs []uint64) {
+			fmt.Printf("%d objects\n", len(addrs))
+			if top > 0 && top < len(addrs) {
+				addrs = addrs[:top]
+			}
+			for _, addr := range addrs {
+				fmt.Println(rubyobj.FormatAddress(addr))
+			}
+		}
+
+		if c.IsSet("address") {
+			addr, err := rubyobj.ParseAddress(c.String("address"))
+			fatal(err)
+			offset, length, ok := idx.Offset(addr)
+			if !ok {
+				fatal(fmt.Errorf("no object at address %s", c.String("address")))
+			}
+			fmt.Printf("%s: %d bytes at offset %d, referenced by ", rubyobj.FormatAddress(addr), length, offset)
+			list(idx.Referrers(addr))
+		}
+		if c.IsSet("class") {
+			fmt.Printf("instances of %s: ", c.String("class"))
+			list(idx.Instances(c.String("class")))
+		}
+	}
+}
+
+func lookupAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		if !c.IsSet("filename") || len(c.Args()) == 0 {
+			cli.ShowCommandHelp(c, command)
+			os.Exit(1)
+		}
+		fr, err := os.Open(c.String("filename"))
+		fatal(err)
+		defer fr.Close()
+
+		var idx rubyobj.OffsetIndex
+		if !c.Bool("no-index") {
+			index, err := rubyobj.OpenIndex(c.String("filename"), uint(runtime.NumCPU()))
+			if index == nil {
+				fatal(err)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+			}
+			defer index.Close()
+			idx = index
+		}
+
+		objects := rubyobj.NewObjectReader(fr, idx)
+		enc := rubyobj.NewEncoder(os.Stdout)
+		for _, arg := range c.Args() {
+			addr, err := rubyobj.ParseAddress(arg)
+			fatal(err)
+			rObj, err := objects.Lookup(addr)
+			if err == rubyobj.ErrNotFound {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", arg, err)
+				continue
+			}
+			fatal(err)
+			fatal(enc.Encode(rObj))
+		}
+	}
+}
+
+// Exports
+
+func dotAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		if !c.IsSet("address") && !c.IsSet("class") && !c.IsSet("where") {
+			cli.ShowCommandHelp(c, command)
+			os.Exit(1)
+		}
+
+		heap := loadHeap(c, command)
+		seeds := seedAddrs(c, heap)
+
+		w := createOutput(c)
+		defer w.Close()
+
+		fatal(rubyobj.WriteDOT(w, heap, seeds, rubyobj.DOTOptions{
+			Depth:    c.Int("depth"),
+			MaxNodes: c.Int("max-nodes"),
+			MaxEdges: c.Int("max-edges"),
+		}))
+	}
+}
+
+func graphAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		var write func(io.Writer, *rubyobj.Heap, func(*rubyobj.RubyObject) bool) error
+		switch c.String("format") {
+		case "graphml":
+			write = rubyobj.WriteGraphML
+		case "gexf":
+			write = rubyobj.WriteGEXF
+		default:
+			fatal(fmt.Errorf("unknown graph format %q", c.String("format")))
+		}
+
+		heap := loadHeap(c, command)
+
+		var keep func(*rubyobj.RubyObject) bool
+		if c.IsSet("type") || c.IsSet("class") || c.IsSet("where") {
+			typ, class := c.String("type"), c.String("class")
+			where := wherePredicate(c, command, heap)
+			keep = func(rObj *rubyobj.RubyObject) bool {
+				if typ != "" && rObj.Type.Name() != typ {
+					return false
+				}
+				if class != "" && heap.ClassName(rObj) != class {
+					return false
+				}
+				return where == nil || where(rObj)
+			}
+		}
+
+		w := createOutput(c)
+		defer w.Close()
+		fatal(write(w, heap, keep))
+	}
+}
+
+func pprofAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		heap := loadHeap(c, command)
+
+		w := createOutput(c)
+		defer w.Close()
+		fatal(rubyobj.WriteProfile(w, heap))
+	}
+}
+
+func exportAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		format := c.String("format")
+		var columns []string
+		if c.IsSet("columns") {
+			columns = strings.Split(c.String("columns"), ",")
+		}
+
+		objects := createFile(c, "objects", "objects."+format)
+		defer objects.Close()
+		edges := createFile(c, "edges", "edges."+format)
+		defer edges.Close()
+
+		var write func(*rubyobj.RubyObject) error
+		var finish func() error
+		switch format {
+		case "csv", "tsv":
+			comma := ','
+			if format == "tsv" {
+				comma = '\t'
+			}
+			tw, err := rubyobj.NewTableWriter(objects, edges, comma, columns)
+			fatal(err)
+			write, finish = tw.Write, tw.Flush
+		case "parquet":
+			if columns != nil {
+				fatal(fmt.Errorf("parquet tables always have all the columns"))
+			}
+			pw, err := rubyobj.NewParquetWriter(objects, edges)
+			fatal(err)
+			write, finish = pw.Write, pw.Close
+		default:
+			fatal(fmt.Errorf("unknown table format %q", format))
+		}
+
+		eachMatchingObject(c, command, func(rObj *rubyobj.RubyObject) {
+			fatal(write(rObj))
+		})
+		fatal(finish())
+	}
+}
+
+func sqliteAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		output := c.String("output")
+		if _, err := os.Stat(output); err == nil {
+			fatal(fmt.Errorf("%s already exists", output))
+		}
+
+		db, err := sql.Open("sqlite3", output)
+		fatal(err)
+		defer db.Close()
+
+		sw, err := rubyobj.NewSQLiteWriter(db)
+		fatal(err)
+
+		eachMatchingObject(c, command, func(rObj *rubyobj.RubyObject) {
+			fatal(sw.Write(rObj))
+		})
+		fatal(sw.Close())
+	}
+}
+
+// seedAddrs are the objects designated by the --address, --class or --where
+// flags.
+func seedAddrs(c *cli.Context, heap *rubyobj.Heap) []uint64 {
+	var seeds []uint64
+	if c.IsSet("address") {
+		addr, err := rubyobj.ParseAddress(c.String("address"))
+		fatal(err)
+		if _, ok := heap.Lookup(addr); !ok {
+			fatal(fmt.Errorf("no object at address %s", c.String("address")))
+		}
+		seeds = append(seeds, addr)
+	}
+	if c.IsSet("class") {
+		instances := heap.Instances(c.String("class"))
+		if len(instances) == 0 {
+			fatal(fmt.Errorf("no instance of class %q", c.String("class")))
+		}
+		seeds = append(seeds, instances...)
+	}
+	if where := wherePredicate(c, "", heap); where != nil {
+		var matches int
+		objects := heap.Objects()
+		for i := range objects {
+			if where(&objects[i]) {
+				seeds = append(seeds, objects[i].Address)
+				matches++
+			}
+		}
+		if matches == 0 {
+			fatal(fmt.Errorf("no object matches %q", c.String("where")))
+		}
+	}
+	return seeds
+}
+
+// createOutput opens the file given with --output, or stdout.
+func createOutput(c *cli.Context) io.WriteCloser {
+	if !c.IsSet("output") {
+		return nopCloser{os.Stdout}
+	}
+	fw, err := os.Create(c.String("output"))
+	fatal(err)
+	return fw
+}
+
+// createFile creates the file named by the flag, or by def if it's not set.
+func createFile(c *cli.Context, flag, def string) *os.File {
+	name := def
+	if c.IsSet(flag) {
+		name = c.String(flag)
+	}
+	fw, err := os.Create(name)
+	fatal(err)
+	return fw
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// loadHeap decodes the file given to command in parallel and indexes it.
+func loadHeap(c *cli.Context, command string) *rubyobj.Heap {
+	return readHeap(loadFile(c, command))
+}
+
+// readHeap decodes fr in parallel, indexes it and closes it.
+func readHeap(fr *os.File) *rubyobj.Heap {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(runtime.NumCPU()))
+	defer fr.Close()
+
+	start := time.Now()
+	heap, err := rubyobj.LoadHeap(fr, uint(runtime.NumCPU()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "%d heap objects in %v\n", heap.Len(), time.Since(start))
+	return heap
+}
+
+// eachObject decodes the file given to command in parallel and calls fn
+// with every object, from a single goroutine.
+func eachObject(c *cli.Context, command string, fn func(*rubyobj.RubyObject)) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(runtime.NumCPU()))
+
+	fr := loadFile(c, command)
+	defer fr.Close()
+
+	objC, errC := rubyobj.ParallelDecode(fr, uint(runtime.NumCPU()))
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go readErr(&wg, errC)
+
+	for obj := range objC {
+		fn(&obj)
+	}
+
+	wg.Wait()
+}
+
+// eachMatchingObject is like eachObject, but only calls fn with the objects
+// matching the --where flag.
+func eachMatchingObject(c *cli.Context, command string, fn func(*rubyobj.RubyObject)) {
+	where := wherePredicate(c, command, nil)
+	if where == nil {
+		eachObject(c, command, fn)
+		return
+	}
+	eachObject(c, command, func(rObj *rubyobj.RubyObject) {
+		if where(rObj) {
+			fn(rObj)
+		}
+	})
+}
+
+// wherePredicate compiles the --where flag, or returns nil if it's not set.
+// Class names are resolved with classes; if it's nil and the filter needs
+// them, the file given to command is read a first time to learn them.
+func wherePredicate(c *cli.Context, command string, classes rubyobj.ClassNamer) func(*rubyobj.RubyObject) bool {
+	if !c.IsSet("where") {
+		return nil
+	}
+	filter, err := rubyobj.CompileFilter(c.String("where"))
+	fatal(err)
+	if classes == nil && filter.UsesClassName() {
+		index := rubyobj.NewClassIndex()
+		eachObject(c, command, index.Add)
+		classes = index
+	}
+	return filter.Predicate(classes)
+}
+
+// Filter
+
+func filterAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		pipeline := rubyobj.NewPipeline()
+		if where := wherePredicate(c, command, nil); where != nil {
+			pipeline.Where(where)
+		}
+		if c.IsSet("fields") {
+			pipeline.Project(strings.Split(c.String("fields"), ",")...)
+		}
+
+		runPipeline(c, command, pipeline)
+	}
+}
+
+// runPipeline runs the pipeline over the dump, writing to the output.
+func runPipeline(c *cli.Context, command string, pipeline *rubyobj.Pipeline) {
+	fr := loadFile(c, command)
+	defer fr.Close()
+	w := createOutput(c)
+	defer w.Close()
+	bw := bufio.NewWriter(w)
+
+	start := time.Now()
+	stats, err := pipeline.Run(fr, bw, uint(runtime.NumCPU()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	}
+	fatal(bw.Flush())
+	fmt.Fprintf(os.Stderr, "wrote %d of %d heap objects in %v\n", stats.Written, stats.Read, time.Since(start))
+}
+
+// Redact
+
+func redactAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		redactor := &rubyobj.Redactor{Key: []byte(c.String("key"))}
+		switch c.String("mode") {
+		case "hash":
+			redactor.Mode = rubyobj.RedactHash
+			if len(redactor.Key) == 0 {
+				fmt.Fprintln(os.Stderr, "warning: without a --key, short values can be guessed from their hash")
+			}
+		case "placeholder":
+			redactor.Mode = rubyobj.RedactPlaceholder
+		default:
+			fatal(fmt.Errorf("unknown redaction mode %q", c.String("mode")))
+		}
+
+		for _, class := range c.StringSlice("class") {
+			redactor.Rules = append(redactor.Rules, rubyobj.RedactRule{Class: class})
+		}
+		for _, expr := range c.StringSlice("file") {
+			re, err := regexp.Compile(expr)
+			fatal(err)
+			redactor.Rules = append(redactor.Rules, rubyobj.RedactRule{File: re})
+		}
+		for _, expr := range c.StringSlice("value") {
+			re, err := regexp.Compile(expr)
+			fatal(err)
+			redactor.Rules = append(redactor.Rules, rubyobj.RedactRule{Value: re})
+		}
+		if len(redactor.Rules) == 0 {
+			redactor.Rules = []rubyobj.RedactRule{{}}
+		}
+		if len(c.StringSlice("class")) != 0 {
+			index := rubyobj.NewClassIndex()
+			eachObject(c, command, index.Add)
+			redactor.Classes = index
+		}
+
+		runPipeline(c, command, rubyobj.NewPipeline().Transform(redactor.Redact))
+	}
+}
+
+// Anonymize
+
+func anonymizeAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		anon := &rubyobj.Anonymizer{ScrubNames: c.Bool("scrub-names")}
+		for _, rule := range c.StringSlice("file-prefix") {
+			i := strings.LastIndex(rule, "=")
+			if i < 0 {
+				fatal(fmt.Errorf("file prefix rule %q isn't like prefix=replacement", rule))
+			}
+			anon.Files = append(anon.Files, rubyobj.FileRule{Prefix: rule[:i], Replace: rule[i+1:]})
+		}
+
+		runPipeline(c, command, rubyobj.NewPipeline().Transform(anon.Anonymize))
+	}
+}
+
+// Validate
+
+func validateAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		fr := loadFile(c, command)
+		defer fr.Close()
+
+		start := time.Now()
+		report, err := rubyobj.Validate(fr, uint(runtime.NumCPU()), c.Int("samples"))
+		fatal(err)
+		fmt.Printf("checked %d heap objects and %d roots in %v\n", report.Objects, report.Roots, time.Since(start))
+
+		if report.Valid() {
+			fmt.Println("no issues found")
+			return
+		}
+		for _, kind := range rubyobj.IssueKinds {
+			count := report.Counts[kind]
+			if count == 0 {
+				continue
+			}
+			fmt.Printf("\n%s: %d\n", kind, count)
+			for _, issue := range report.Samples[kind] {
+				fmt.Printf("\t%v\n", issue)
+			}
+		}
+		os.Exit(1)
+	}
+}
+
+// Stats
+
+func statsAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		report := rubyobj.NewStatsReport()
+		eachObject(c, command, report.Add)
+		stats := report.Stats()
+
+		if c.Bool("json") {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			fatal(enc.Encode(stats))
+			return
+		}
+
+		fmt.Printf("%d objects, %s, %d references\n\n", stats.Objects, humanize.Bytes(stats.Memsize), stats.References)
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "type\tcount\tmemsize\t%% memsize\n")
+		for _, ts := range stats.Types {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%.1f%%\n", ts.Type, ts.Count, humanize.Bytes(ts.Memsize), percent(ts.Memsize, stats.Memsize))
+		}
+		tw.Flush()
+
+		fmt.Println()
+		tw = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "\tmin\tp50\tp90\tp99\tmax\tmean\n")
+		for _, dist := range []struct {
+			name string
+			p    rubyobj.Percentiles
+		}{
+			{"memsize", stats.MemsizePercentiles},
+			{"references", stats.OutDegreePercentiles},
+			{"referrers", stats.InDegreePercentiles},
+			{"generation", stats.Generations},
+		} {
+			p := dist.p
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%.1f\n", dist.name, p.Min, p.P50, p.P90, p.P99, p.Max, p.Mean)
+		}
+		tw.Flush()
+		if stats.WithoutGenerations != 0 {
+			fmt.Printf("%d objects without generation information\n", stats.WithoutGenerations)
+		}
+
+		fmt.Println()
+		f := stats.Flags
+		tw = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "flag\tcount\t%% objects\n")
+		for _, flag := range []struct {
+			name  string
+			count uint64
+		}{
+			{"frozen", f.Frozen}, {"old", f.Old}, {"wb_protected", f.WbProtected}, {"shared", f.Shared}, {"embedded", f.Embedded},
+		} {
+			fmt.Fprintf(tw, "%s\t%d\t%.1f%%\n", flag.name, flag.count, percent(flag.count, stats.Objects))
+		}
+		tw.Flush()
+
+		fmt.Println()
+		tw = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "root\treferences\n")
+		for _, root := range stats.Roots {
+			fmt.Fprintf(tw, "%s\t%d\n", root.Category, root.References)
+		}
+		tw.Flush()
+	}
+}
+
+func percent(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// Time series
+
+func timeseriesAction(command string) func(*cli.Context) {
+	return func(c *cli.Context) {
+		if len(c.Args()) == 0 {
+			cli.ShowCommandHelp(c, command)
+			os.Exit(1)
+		}
+		format := c.String("format")
+		if format != "text" && format != "csv" && format != "json" {
+			fatal(fmt.Errorf("unknown format %q", format))
+		}
+
+		series := rubyobj.NewTimeSeries()
+		for _, filename := range c.Args() {
+			fr := openFile(filename)
+			err := series.AddDump(filepath.Base(filename), fr, uint(runtime.NumCPU()))
+			fr.Close()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			}
+		}
+
+		w := createOutput(c)
+		defer w.Close()
+		switch format {
+		case "csv":
+			fatal(series.WriteCSV(w))
+		case "json":
+			fatal(json.NewEncoder(w).Encode(series))
+		default:
+			printLeakCandidates(w, "classes", "class", series.Classes, c.Int("top"))
+			printLeakCandidates(w, "allocation sites", "site", series.Sites, c.Int("top"))
+		}
+	}
+}
+
+func printLeakCandidates(w io.Writer, kind, column string, series []*rubyobj.Series, top int) {
+	var leaks []*rubyobj.Series
+	for _, s := range series {
+		if s.LeakCandidate {
+			leaks = append(leaks, s)
+		}
+	}
+	fmt.Fprintf(w, "%d %s growing in every dump\n", len(leaks), kind)
+	if top > 0 && top < len(leaks) {
+		leaks = leaks[:top]
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "count\tmemsize\t%s\n", column)
+	for _, s := range leaks {
+		last := len(s.Counts) - 1
+		fmt.Fprintf(tw, "%d -> %d\t%s -> %s\t%s\n",
+			s.Counts[0], s.Counts[last],
+			humanize.Bytes(s.Memsizes[0]), humanize.Bytes(s.Memsizes[last]), s.Key)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+}
+
 func readErr(wg *sync.WaitGroup, errC <-chan error) {
 	defer wg.Done()
 	for err := range errC {
@@ -111,19 +1805,50 @@ func loadFile(c *cli.Context, command string) *os.File {
 		cli.ShowCommandHelp(c, command)
 		os.Exit(1)
 	}
+	return openFile(c.String("filename"))
+}
 
-	fr, err := os.Open(c.String("filename"))
+func openFile(filename string) *os.File {
+	fr, err := os.Open(filename)
 	fatal(err)
 
 	fStat, err := fr.Stat()
 	fatal(err)
 	byteSize := fStat.Size()
 
-	fmt.Printf("loading %s from '%s'\n", humanize.Bytes(uint64(byteSize)), fStat.Name())
+	fmt.Fprintf(os.Stderr, "loading %s from '%s'\n", humanize.Bytes(uint64(byteSize)), fStat.Name())
 
 	return fr
 }
 
+// preview quotes str, truncated to about max characters.
+func preview(str string, max int) string {
+	return strconv.Quote(truncate(str, max))
+}
+
+func truncate(str string, max int) string {
+	if len(str) > max {
+		str = str[:max] + "..."
+	}
+	return str
+}
+
+// formatValue is the value of rObj, empty if it has none.
+func formatValue(rObj *rubyobj.RubyObject) string {
+	if rObj.Value == nil {
+		return ""
+	}
+	return fmt.Sprint(rObj.Value)
+}
+
+func formatAddrs(addrs []uint64) string {
+	strs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		strs[i] = rubyobj.FormatAddress(addr)
+	}
+	return strings.Join(strs, " ")
+}
+
 func fatal(err error) {
 	if err == nil {
 		return
@@ -0,0 +1,511 @@
+package rubyobj
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ClassNamer resolves the name of the class of objects.  A Heap is a
+// ClassNamer, and so is a ClassIndex.
+type ClassNamer interface {
+	ClassName(rObj *RubyObject) string
+}
+
+// ClassIndex learns the names of classes from the CLASS and MODULE objects
+// it's given, for when objects are decoded one by one rather than loaded in a
+// Heap.
+type ClassIndex struct {
+	classes classNames
+}
+
+// NewClassIndex creates an index that knows no class.
+func NewClassIndex() *ClassIndex {
+	return &ClassIndex{classes: make(classNames)}
+}
+
+// Add learns the name of rObj if it's a class or a module.
+func (c *ClassIndex) Add(rObj *RubyObject) { c.classes.observe(rObj) }
+
+// ClassName is the name of the class of rObj, or the address of the class if
+// it's not known.
+func (c *ClassIndex) ClassName(rObj *RubyObject) string { return c.classes.name(rObj.Class) }
+
+// Filter is a compiled filter expression, which tells if RubyObjects match
+// conditions on their fields.  Expressions compare fields to literals:
+//
+//	type == STRING && memsize > 1024 && file =~ "app/models"
+//	class_name == "User" || (frozen && !embedded)
+//
+// Comparisons are ==, !=, <, <=, > and >=, and =~ and !~ to match strings
+// against regular expressions.  Strings are between double quotes, in which
+// only \" and \\ are escapes so that regular expressions can be written as
+// is, or between backquotes, in which nothing is.  Comparisons are combined
+// with &&, || and !, and grouped with parentheses.  Fields are named like the
+// columns of the objects table, plus:
+//
+//	class_name: resolved name of the class of the object
+//	references: number of references held by the object
+//	gc_old, gc_marked and gc_wb_protected: aliases of old, marked and
+//	wb_protected
+//
+// Boolean fields can be used by themselves as conditions.  Addresses are
+// compared to hexadecimal literals like 0x7fc96c8337a8, and types to their
+// name like STRING.
+type Filter struct {
+	expr          string
+	match         func(*RubyObject, ClassNamer) bool
+	usesClassName bool
+}
+
+// CompileFilter parses expr.
+func CompileFilter(expr string) (*Filter, error) {
+	p := &filterParser{lex: filterLexer{src: expr}}
+	p.next()
+	match, err := p.parseOr()
+	if err == nil && p.tok.kind != tokEOF {
+		err = p.unexpected()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("filter %q: %v", expr, err)
+	}
+	return &Filter{expr: expr, match: match, usesClassName: p.usesClassName}, nil
+}
+
+func (f *Filter) String() string { return f.expr }
+
+// Match tells if rObj matches the filter, resolving class names with
+// classes.  classes can be nil if the filter doesn't use class_name.
+func (f *Filter) Match(rObj *RubyObject, classes ClassNamer) bool {
+	return f.match(rObj, classes)
+}
+
+// Predicate binds the filter to classes, for functions expecting a predicate.
+func (f *Filter) Predicate(classes ClassNamer) func(*RubyObject) bool {
+	return func(rObj *RubyObject) bool { return f.match(rObj, classes) }
+}
+
+// UsesClassName tells if the filter needs to resolve class names, in which
+// case the ClassNamer given to Match must know the classes of the dump.
+func (f *Filter) UsesClassName() bool { return f.usesClassName }
+
+// Fields
+
+type fieldKind int
+
+const (
+	stringField fieldKind = iota
+	uintField
+	intField
+	boolField
+	addrField
+	typeField
+)
+
+type filterField struct {
+	kind fieldKind
+	str  func(*RubyObject, ClassNamer) string
+	num  func(*RubyObject) uint64
+	flag func(RubyObject) bool
+}
+
+func strField(f func(*RubyObject) string) filterField {
+	return filterField{kind: stringField, str: func(o *RubyObject, _ ClassNamer) string { return f(o) }}
+}
+
+func numField(f func(*RubyObject) uint64) filterField {
+	return filterField{kind: uintField, num: f}
+}
+
+// signedField compares the values of f as uint64s, shifted so that they keep
+// their order.
+func signedField(f func(*RubyObject) int64) filterField {
+	return filterField{kind: intField, num: func(o *RubyObject) uint64 { return signedOrder(f(o)) }}
+}
+
+func signedOrder(v int64) uint64 { return uint64(v) ^ 1<<63 }
+
+func addressField(f func(*RubyObject) uint64) filterField {
+	return filterField{kind: addrField, num: f}
+}
+
+func flagField(f func(RubyObject) bool) filterField {
+	return filterField{kind: boolField, flag: f}
+}
+
+var filterFields = map[string]filterField{
+	"type":            {kind: typeField, num: func(o *RubyObject) uint64 { return uint64(o.Type) }},
+	"root":            strField(func(o *RubyObject) string { return o.Root }),
+	"address":         addressField(func(o *RubyObject) uint64 { return o.Address }),
+	"class":           addressField(func(o *RubyObject) uint64 { return o.Class }),
+	"default":         addressField(func(o *RubyObject) uint64 { return o.Default }),
+	"node_type":       strField(func(o *RubyObject) string { return o.NodeType }),
+	"value":           strField(formatValue),
+	"name":            strField(func(o *RubyObject) string { return o.Name }),
+	"file":            strField(func(o *RubyObject) string { return o.File }),
+	"method":          strField(func(o *RubyObject) string { return o.Method }),
+	"encoding":        strField(func(o *RubyObject) string { return o.Encoding }),
+	"struct":          strField(func(o *RubyObject) string { return o.Struct }),
+	"generation":      numField(func(o *RubyObject) uint64 { return o.Generation }),
+	"bytesize":        numField(func(o *RubyObject) uint64 { return o.Bytesize }),
+	"fd":              signedField(func(o *RubyObject) int64 { return int64(o.Fd) }),
+	"line":            numField(func(o *RubyObject) uint64 { return o.Line }),
+	"ivars":           numField(func(o *RubyObject) uint64 { return o.Ivars }),
+	"length":          numField(func(o *RubyObject) uint64 { return o.Length }),
+	"memsize":         numField(func(o *RubyObject) uint64 { return o.Memsize }),
+	"capacity":        numField(func(o *RubyObject) uint64 { return o.Capacity }),
+	"size":            numField(func(o *RubyObject) uint64 { return o.Size }),
+	"references":      numField(func(o *RubyObject) uint64 { return uint64(len(o.References)) }),
+	"frozen":          flagField(RubyObject.Frozen),
+	"embedded":        flagField(RubyObject.Embedded),
+	"broken":          flagField(RubyObject.Broken),
+	"fstring":         flagField(RubyObject.Fstring),
+	"shared":          flagField(RubyObject.Shared),
+	"wb_protected":    flagField(RubyObject.GcWbProtected),
+	"gc_wb_protected": flagField(RubyObject.GcWbProtected),
+	"old":             flagField(RubyObject.GcOld),
+	"gc_old":          flagField(RubyObject.GcOld),
+	"marked":          flagField(RubyObject.GcMarked),
+	"gc_marked":       flagField(RubyObject.GcMarked),
+	"class_name": {kind: stringField, str: func(o *RubyObject, classes ClassNamer) string {
+		if classes == nil {
+			return formatUint64(o.Class)
+		}
+		return classes.ClassName(o)
+	}},
+}
+
+// Lexer
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+type filterLexer struct {
+	src string
+	pos int
+}
+
+// filterOps are the operators of filters, and the | and * of queries.
+var filterOps = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")", "|", "*"}
+
+func (l *filterLexer) next() (token, error) {
+	for l.pos < len(l.src) && unicode.IsSpace(rune(l.src[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case c == '"':
+		var str []byte
+		for l.pos++; l.pos < len(l.src) && l.src[l.pos] != '"'; l.pos++ {
+			if l.src[l.pos] == '\\' && l.pos+1 < len(l.src) && (l.src[l.pos+1] == '"' || l.src[l.pos+1] == '\\') {
+				l.pos++
+			}
+			str = append(str, l.src[l.pos])
+		}
+		if l.pos >= len(l.src) {
+			return token{}, fmt.Errorf("unterminated string at offset %d", start)
+		}
+		l.pos++
+		return token{kind: tokString, text: string(str), pos: start}, nil
+
+	case c == '`':
+		end := strings.IndexByte(l.src[l.pos+1:], '`')
+		if end < 0 {
+			return token{}, fmt.Errorf("unterminated string at offset %d", start)
+		}
+		l.pos += end + 2
+		return token{kind: tokString, text: l.src[start+1 : l.pos-1], pos: start}, nil
+
+	case c >= '0' && c <= '9',
+		c == '-' && l.pos+1 < len(l.src) && l.src[l.pos+1] >= '0' && l.src[l.pos+1] <= '9':
+		for l.pos++; l.pos < len(l.src) && isIdentChar(l.src[l.pos]); l.pos++ {
+		}
+		return token{kind: tokNumber, text: l.src[start:l.pos], pos: start}, nil
+
+	case isIdentChar(c):
+		for l.pos < len(l.src) && isIdentChar(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.src[start:l.pos], pos: start}, nil
+	}
+
+	for _, op := range filterOps {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+	return token{}, fmt.Errorf("unexpected %q at offset %d", c, start)
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Parser
+
+type matchFunc func(*RubyObject, ClassNamer) bool
+
+type filterParser struct {
+	lex filterLexer
+	tok token
+	err error
+
+	usesClassName bool
+}
+
+func (p *filterParser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+}
+
+func (p *filterParser) isOp(op string) bool {
+	return p.err == nil && p.tok.kind == tokOp && p.tok.text == op
+}
+
+func (p *filterParser) unexpected() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.tok.kind == tokEOF {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected %q at offset %d", p.tok.text, p.tok.pos)
+}
+
+func (p *filterParser) parseOr() (matchFunc, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(o *RubyObject, c ClassNamer) bool { return l(o, c) || right(o, c) }
+	}
+	return left, p.err
+}
+
+func (p *filterParser) parseAnd() (matchFunc, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(o *RubyObject, c ClassNamer) bool { return l(o, c) && right(o, c) }
+	}
+	return left, p.err
+}
+
+func (p *filterParser) parseNot() (matchFunc, error) {
+	if p.isOp("!") {
+		p.next()
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(o *RubyObject, c ClassNamer) bool { return !inner(o, c) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (matchFunc, error) {
+	if p.isOp("(") {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(")") {
+			return nil, p.unexpected()
+		}
+		p.next()
+		return inner, nil
+	}
+
+	if p.err != nil || p.tok.kind != tokIdent {
+		return nil, p.unexpected()
+	}
+	name := p.tok.text
+	field, ok := filterFields[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q at offset %d", name, p.tok.pos)
+	}
+	if name == "class_name" {
+		p.usesClassName = true
+	}
+	p.next()
+
+	if p.err != nil || p.tok.kind != tokOp || !isComparison(p.tok.text) {
+		if field.kind == boolField {
+			flag := field.flag
+			return func(o *RubyObject, _ ClassNamer) bool { return flag(*o) }, p.err
+		}
+		return nil, p.unexpected()
+	}
+	op := p.tok
+	p.next()
+	if p.err != nil {
+		return nil, p.err
+	}
+	lit := p.tok
+	if lit.kind != tokIdent && lit.kind != tokNumber && lit.kind != tokString {
+		return nil, p.unexpected()
+	}
+	p.next()
+
+	match, err := compileComparison(name, field, op.text, lit)
+	if err != nil {
+		return nil, fmt.Errorf("%v at offset %d", err, op.pos)
+	}
+	return match, p.err
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+		return true
+	}
+	return false
+}
+
+// compileComparison builds the test of field against the literal lit.
+func compileComparison(name string, field filterField, op string, lit token) (matchFunc, error) {
+	switch field.kind {
+	case stringField:
+		if lit.kind != tokString {
+			return nil, fmt.Errorf("%s must be compared to a string", name)
+		}
+		str := field.str
+		if op == "=~" || op == "!~" {
+			re, err := regexp.Compile(lit.text)
+			if err != nil {
+				return nil, err
+			}
+			want := op == "=~"
+			return func(o *RubyObject, c ClassNamer) bool { return re.MatchString(str(o, c)) == want }, nil
+		}
+		cmp := compareStrings(op)
+		return func(o *RubyObject, c ClassNamer) bool { return cmp(str(o, c), lit.text) }, nil
+
+	case uintField, addrField:
+		var v uint64
+		var err error
+		if field.kind == addrField || strings.HasPrefix(lit.text, "0x") {
+			v, err = ParseAddress(lit.text)
+		} else {
+			v, err = strconv.ParseUint(lit.text, 10, 64)
+		}
+		if lit.kind != tokNumber || err != nil {
+			return nil, fmt.Errorf("%s must be compared to a number", name)
+		}
+		cmp, err := compareUints(op)
+		if err != nil {
+			return nil, err
+		}
+		num := field.num
+		return func(o *RubyObject, _ ClassNamer) bool { return cmp(num(o), v) }, nil
+
+	case intField:
+		v, err := strconv.ParseInt(lit.text, 10, 64)
+		if lit.kind != tokNumber || err != nil {
+			return nil, fmt.Errorf("%s must be compared to a number", name)
+		}
+		cmp, err := compareUints(op)
+		if err != nil {
+			return nil, err
+		}
+		num, want := field.num, signedOrder(v)
+		return func(o *RubyObject, _ ClassNamer) bool { return cmp(num(o), want) }, nil
+
+	case boolField:
+		if lit.kind != tokIdent || (lit.text != "true" && lit.text != "false") {
+			return nil, fmt.Errorf("%s must be compared to true or false", name)
+		}
+		if op != "==" && op != "!=" {
+			return nil, fmt.Errorf("%s can't be compared with %s", name, op)
+		}
+		want := (lit.text == "true") == (op == "==")
+		flag := field.flag
+		return func(o *RubyObject, _ ClassNamer) bool { return flag(*o) == want }, nil
+
+	case typeField:
+		typ, err := typeFromName(strings.ToUpper(lit.text))
+		if err != nil {
+			return nil, err
+		}
+		if op != "==" && op != "!=" {
+			return nil, fmt.Errorf("%s can't be compared with %s", name, op)
+		}
+		want := op == "=="
+		return func(o *RubyObject, _ ClassNamer) bool { return (o.Type == typ) == want }, nil
+	}
+	return nil, fmt.Errorf("can't compare %s", name)
+}
+
+func compareStrings(op string) func(a, b string) bool {
+	switch op {
+	case "==":
+		return func(a, b string) bool { return a == b }
+	case "!=":
+		return func(a, b string) bool { return a != b }
+	case "<":
+		return func(a, b string) bool { return a < b }
+	case "<=":
+		return func(a, b string) bool { return a <= b }
+	case ">":
+		return func(a, b string) bool { return a > b }
+	default:
+		return func(a, b string) bool { return a >= b }
+	}
+}
+
+func compareUints(op string) (func(a, b uint64) bool, error) {
+	switch op {
+	case "==":
+		return func(a, b uint64) bool { return a == b }, nil
+	case "!=":
+		return func(a, b uint64) bool { return a != b }, nil
+	case "<":
+		return func(a, b uint64) bool { return a < b }, nil
+	case "<=":
+		return func(a, b uint64) bool { return a <= b }, nil
+	case ">":
+		return func(a, b uint64) bool { return a > b }, nil
+	case ">=":
+		return func(a, b uint64) bool { return a >= b }, nil
+	}
+	return nil, fmt.Errorf("numbers can't be compared with %s", op)
+}
@@ -0,0 +1,121 @@
+package rubyobj_test
+
+import (
+	"github.com/aybabtme/rubyobj"
+	"strings"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	objs := decodeDump(t, strings.NewReader(`{"address":"0x7fc96c000100", "type":"CLASS", "name":"String"}
+{"address":"0x7fc96c000001", "type":"STRING", "class":"0x7fc96c000100", "frozen":true, "embedded":true, "bytesize":5, "value":"hello", "file":"app.rb", "line":12, "memsize":40, "references":["0x7fc96c000003"], "flags":{"old":true}}
+{"address":"0x7fc96c000002", "type":"FILE", "fd":-1, "memsize":200}
+{"address":"0x7fc96c000003", "type":"STRING", "class":"0x7fc96c000100", "bytesize":5, "value":"a\"b\\c", "file":"lib/v2.rb", "memsize":40}
+`))
+	classes := rubyobj.NewClassIndex()
+	for i := range objs {
+		classes.Add(&objs[i])
+	}
+	str, file, quoted := &objs[1], &objs[2], &objs[3]
+
+	tests := []struct {
+		expr string
+		want [3]bool // str, file, quoted
+	}{
+		{`type == STRING`, [3]bool{true, false, true}},
+		{`type != string`, [3]bool{false, true, false}},
+		{`address == 0x7fc96c000002`, [3]bool{false, true, false}},
+		{`class == 0x7fc96c000100`, [3]bool{true, false, true}},
+		{`class_name == "String"`, [3]bool{true, false, true}},
+
+		{`memsize == 40`, [3]bool{true, false, true}},
+		{`memsize != 40`, [3]bool{false, true, false}},
+		{`memsize < 200`, [3]bool{true, false, true}},
+		{`memsize <= 200`, [3]bool{true, true, true}},
+		{`memsize > 40`, [3]bool{false, true, false}},
+		{`memsize >= 40`, [3]bool{true, true, true}},
+		{`line == 0xc`, [3]bool{true, false, false}},
+		{`references > 0`, [3]bool{true, false, false}},
+
+		{`fd == -1`, [3]bool{false, true, false}},
+		{`fd > 0`, [3]bool{false, false, false}},
+		{`fd < 0`, [3]bool{false, true, false}},
+		{`fd >= -1`, [3]bool{true, true, true}},
+
+		{`value == "hello"`, [3]bool{true, false, false}},
+		{`value != "hello"`, [3]bool{false, true, true}},
+		{`value < "b"`, [3]bool{false, true, true}},
+		{`value <= "hello"`, [3]bool{true, true, true}},
+		{`value > "b"`, [3]bool{true, false, false}},
+		{`value >= "hello"`, [3]bool{true, false, false}},
+		{`value == "a\"b\\c"`, [3]bool{false, false, true}},
+		{"value == `a\"b\\c`", [3]bool{false, false, true}},
+
+		{`file =~ "app\.rb"`, [3]bool{true, false, false}},
+		{`file =~ "\d+"`, [3]bool{false, false, true}},
+		{"file =~ `^lib/v\\d`", [3]bool{false, false, true}},
+		{`file !~ "\.rb$"`, [3]bool{false, true, false}},
+
+		{`frozen`, [3]bool{true, false, false}},
+		{`!frozen`, [3]bool{false, true, true}},
+		{`frozen == true`, [3]bool{true, false, false}},
+		{`frozen != true`, [3]bool{false, true, true}},
+		{`embedded == false`, [3]bool{false, true, true}},
+		{`old && gc_old`, [3]bool{true, false, false}},
+
+		// && binds tighter than ||, and ! tighter than both.
+		{`type == FILE || type == STRING && frozen`, [3]bool{true, true, false}},
+		{`(type == FILE || type == STRING) && frozen`, [3]bool{true, false, false}},
+		{`type == STRING && frozen || type == FILE`, [3]bool{true, true, false}},
+		{`!frozen && type == STRING`, [3]bool{false, false, true}},
+		{`!(frozen || type == FILE)`, [3]bool{false, false, true}},
+		{`!!frozen`, [3]bool{true, false, false}},
+	}
+	for _, tt := range tests {
+		f, err := rubyobj.CompileFilter(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		for i, rObj := range []*rubyobj.RubyObject{str, file, quoted} {
+			if got := f.Match(rObj, classes); got != tt.want[i] {
+				t.Errorf("%s: want %v on object %d, got %v", tt.expr, tt.want[i], i, got)
+			}
+		}
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{``, "unexpected end of expression"},
+		{`memsize >`, "unexpected end of expression"},
+		{`frozen &&`, "unexpected end of expression"},
+		{`(frozen`, "unexpected end of expression"},
+		{`frozen)`, `unexpected ")"`},
+		{`frozen frozen`, `unexpected "frozen"`},
+		{`memsize @ 1`, `unexpected '@'`},
+		{`nope == 1`, `unknown field "nope"`},
+		{`memsize > "1"`, "memsize must be compared to a number"},
+		{`memsize > -1`, "memsize must be compared to a number"},
+		{`fd > "1"`, "fd must be compared to a number"},
+		{`memsize =~ 1`, "numbers can't be compared with =~"},
+		{`file == 1`, "file must be compared to a string"},
+		{`file =~ "("`, "missing closing )"},
+		{`frozen < true`, "frozen can't be compared with <"},
+		{`frozen == 1`, "frozen must be compared to true or false"},
+		{`type < STRING`, "type can't be compared with <"},
+		{`file == "app.rb`, "unterminated string"},
+		{"file == `app.rb", "unterminated string"},
+	}
+	for _, tt := range tests {
+		_, err := rubyobj.CompileFilter(tt.expr)
+		if err == nil {
+			t.Errorf("%s: want an error", tt.expr)
+		} else if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: want an error about %q, got %v", tt.expr, tt.want, err)
+		}
+	}
+}
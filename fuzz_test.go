@@ -0,0 +1,91 @@
+package rubyobj
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// FuzzDecoders feeds lines to both the json Decoder and the fatherhood one
+// used by ParallelDecode.  Neither may panic, and when both decode a line,
+// they must agree on the object.
+func FuzzDecoders(f *testing.F) {
+	names, err := filepath.Glob("testdata/*.json")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		// A few lines of each type are enough, more only slow the fuzzer down.
+		seen := make(map[string]int)
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			typ := lineType(line)
+			if len(line) != 0 && seen[typ] < seedsPerType {
+				seen[typ]++
+				f.Add(line)
+			}
+		}
+	}
+
+	good := []byte(`{"address":"0x7fc96c8337a8", "type":"STRING", "bytesize":3, "value":"abc"}`)
+
+	f.Fuzz(func(t *testing.T, line []byte) {
+		var fromJSON, fromFatherhood RubyObject
+		jsonErr := NewDecoder(bytes.NewReader(line)).Decode(&fromJSON)
+		dec := newLineDecoder()
+		fatherhoodErr := dec.decode(line, &fromFatherhood)
+
+		// Whatever the line was, the decoder must still decode the next one.
+		var next RubyObject
+		if err := dec.decode(good, &next); err != nil {
+			t.Fatalf("decoding a good line after %q: %v", line, err)
+		}
+		if next.Address != 0x7fc96c8337a8 || next.Value != "abc" {
+			t.Fatalf("decoding a good line after %q: got %+v", line, next)
+		}
+
+		if jsonErr != nil || fatherhoodErr != nil {
+			return
+		}
+		if !sameObject(fromJSON, fromFatherhood) {
+			t.Errorf("decoders disagree on %q:\njson:       %+v\nfatherhood: %+v", line, fromJSON, fromFatherhood)
+		}
+	})
+}
+
+const seedsPerType = 5
+
+var typeKey = []byte(`"type":"`)
+
+// lineType finds the type of the object of a line, without decoding it.
+func lineType(line []byte) string {
+	i := bytes.Index(line, typeKey)
+	if i < 0 {
+		return ""
+	}
+	rest := line[i+len(typeKey):]
+	if end := bytes.IndexByte(rest, '"'); end >= 0 {
+		return string(rest[:end])
+	}
+	return ""
+}
+
+// sameObject compares objects like reflect.DeepEqual, except that NaN values
+// are equal.
+func sameObject(a, b RubyObject) bool {
+	af, aok := a.Value.(float64)
+	bf, bok := b.Value.(float64)
+	if aok && bok && math.IsNaN(af) && math.IsNaN(bf) {
+		a.Value, b.Value = nil, nil
+	}
+	if len(a.References) == 0 && len(b.References) == 0 {
+		a.References, b.References = nil, nil
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,194 @@
+package rubyobj
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// nodeAttr is a property of RubyObjects exported as a node attribute.  Its
+// value is "" when the attribute isn't set on an object.
+type nodeAttr struct {
+	name  string
+	typ   string // one of string, long or boolean, valid in GraphML and GEXF
+	value func(h *Heap, rObj *RubyObject) string
+}
+
+// nodeAttrs are the columns of the objects table, less the address which
+// identifies nodes and the root category which only ROOT records have, and
+// the class name and root status of the objects.
+var nodeAttrs = func() []nodeAttr {
+	attrs := []nodeAttr{
+		{"class_name", "string", func(h *Heap, o *RubyObject) string {
+			if o.Class == 0 {
+				return ""
+			}
+			return h.ClassName(o)
+		}},
+		{"root", "boolean", func(h *Heap, o *RubyObject) string {
+			if !h.IsRoot(o.Address) {
+				return ""
+			}
+			return "true"
+		}},
+	}
+	for _, col := range objectColumns {
+		if col.name == "address" || col.name == "root" {
+			continue
+		}
+		col := col
+		attrs = append(attrs, nodeAttr{col.name, col.typ, func(h *Heap, o *RubyObject) string {
+			v := col.value(o)
+			if (col.typ == "long" && v == "0") || (col.typ == "boolean" && v == "false") {
+				return ""
+			}
+			return v
+		}})
+	}
+	return attrs
+}()
+
+// xmlWriter writes XML to a buffered writer, remembering the first error.
+type xmlWriter struct {
+	bw  *bufio.Writer
+	err error
+}
+
+func (x *xmlWriter) printf(format string, args ...interface{}) {
+	if x.err == nil {
+		_, x.err = fmt.Fprintf(x.bw, format, args...)
+	}
+}
+
+// attr writes ` name="value"` with value escaped.
+func (x *xmlWriter) attr(name, value string) {
+	x.printf(` %s="`, name)
+	x.text(value)
+	x.printf(`"`)
+}
+
+func (x *xmlWriter) text(str string) {
+	if x.err == nil {
+		x.err = xml.EscapeText(x.bw, []byte(str))
+	}
+}
+
+func (x *xmlWriter) flush() error {
+	if x.err != nil {
+		return x.err
+	}
+	return x.bw.Flush()
+}
+
+// eachEdge calls fn with the distinct references of the objects kept by the
+// filter, between two kept objects.
+func eachEdge(h *Heap, keep func(*RubyObject) bool, fn func(id int, from, to uint64)) {
+	id := 0
+	objs := h.Objects()
+	for i := range objs {
+		if keep != nil && !keep(&objs[i]) {
+			continue
+		}
+		seen := make(map[uint64]bool, len(objs[i].References))
+		for _, to := range objs[i].References {
+			if seen[to] {
+				continue
+			}
+			seen[to] = true
+			target, ok := h.Lookup(to)
+			if !ok || (keep != nil && !keep(target)) {
+				continue
+			}
+			fn(id, objs[i].Address, to)
+			id++
+		}
+	}
+}
+
+// WriteGraphML writes the objects of the heap that keep accepts, or all of
+// them if keep is nil, as a GraphML document.  Every field of the objects is a
+// node attribute and every reference between two written objects is an edge.
+// The document is written as the heap is walked, without being held in memory.
+func WriteGraphML(w io.Writer, h *Heap, keep func(*RubyObject) bool) error {
+	x := &xmlWriter{bw: bufio.NewWriter(w)}
+
+	x.printf("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	x.printf("<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">\n")
+	for _, a := range nodeAttrs {
+		x.printf("  <key id=%q for=\"node\" attr.name=%q attr.type=%q/>\n", a.name, a.name, a.typ)
+	}
+	x.printf("  <graph id=\"heap\" edgedefault=\"directed\">\n")
+
+	objs := h.Objects()
+	for i := range objs {
+		rObj := &objs[i]
+		if keep != nil && !keep(rObj) {
+			continue
+		}
+		x.printf("    <node id=%q>", FormatAddress(rObj.Address))
+		for _, a := range nodeAttrs {
+			if v := a.value(h, rObj); v != "" {
+				x.printf("<data key=%q>", a.name)
+				x.text(v)
+				x.printf("</data>")
+			}
+		}
+		x.printf("</node>\n")
+	}
+
+	eachEdge(h, keep, func(id int, from, to uint64) {
+		x.printf("    <edge id=\"e%d\" source=%q target=%q/>\n", id, FormatAddress(from), FormatAddress(to))
+	})
+
+	x.printf("  </graph>\n</graphml>\n")
+	return x.flush()
+}
+
+// WriteGEXF writes the objects of the heap that keep accepts, or all of them
+// if keep is nil, as a GEXF document.  Every field of the objects is a node
+// attribute and every reference between two written objects is an edge.  The
+// document is written as the heap is walked, without being held in memory.
+func WriteGEXF(w io.Writer, h *Heap, keep func(*RubyObject) bool) error {
+	x := &xmlWriter{bw: bufio.NewWriter(w)}
+
+	x.printf("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	x.printf("<gexf xmlns=\"http://www.gexf.net/1.2draft\" version=\"1.2\">\n")
+	x.printf("  <graph mode=\"static\" defaultedgetype=\"directed\">\n")
+	x.printf("    <attributes class=\"node\">\n")
+	for i, a := range nodeAttrs {
+		x.printf("      <attribute id=\"%d\" title=%q type=%q/>\n", i, a.name, a.typ)
+	}
+	x.printf("    </attributes>\n")
+
+	x.printf("    <nodes>\n")
+	objs := h.Objects()
+	for i := range objs {
+		rObj := &objs[i]
+		if keep != nil && !keep(rObj) {
+			continue
+		}
+		x.printf("      <node")
+		x.attr("id", FormatAddress(rObj.Address))
+		x.attr("label", nodeLabel(h, rObj))
+		x.printf("><attvalues>")
+		for j, a := range nodeAttrs {
+			if v := a.value(h, rObj); v != "" {
+				x.printf("<attvalue for=\"%d\"", j)
+				x.attr("value", v)
+				x.printf("/>")
+			}
+		}
+		x.printf("</attvalues></node>\n")
+	}
+	x.printf("    </nodes>\n")
+
+	x.printf("    <edges>\n")
+	eachEdge(h, keep, func(id int, from, to uint64) {
+		x.printf("      <edge id=\"%d\" source=%q target=%q/>\n", id, FormatAddress(from), FormatAddress(to))
+	})
+	x.printf("    </edges>\n")
+
+	x.printf("  </graph>\n</gexf>\n")
+	return x.flush()
+}
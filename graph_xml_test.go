@@ -0,0 +1,231 @@
+package rubyobj_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/aybabtme/rubyobj"
+	"io/ioutil"
+	"strconv"
+	"testing"
+)
+
+// xmlGraph is a GraphML or GEXF document read back: the attributes of the
+// nodes by id, and the edges in the order they were written.
+type xmlGraph struct {
+	nodes map[string]map[string]string
+	edges []xmlEdge
+}
+
+type xmlEdge struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+func parseGraphML(t *testing.T, data []byte) xmlGraph {
+	var doc struct {
+		Keys []struct {
+			ID   string `xml:"id,attr"`
+			Name string `xml:"attr.name,attr"`
+		} `xml:"key"`
+		Nodes []struct {
+			ID   string `xml:"id,attr"`
+			Data []struct {
+				Key   string `xml:"key,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"data"`
+		} `xml:"graph>node"`
+		Edges []xmlEdge `xml:"graph>edge"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]string)
+	for _, k := range doc.Keys {
+		names[k.ID] = k.Name
+	}
+	g := xmlGraph{nodes: make(map[string]map[string]string), edges: doc.Edges}
+	for _, n := range doc.Nodes {
+		if _, ok := g.nodes[n.ID]; ok {
+			t.Fatalf("node %s written twice", n.ID)
+		}
+		attrs := make(map[string]string)
+		for _, d := range n.Data {
+			if names[d.Key] == "" {
+				t.Fatalf("node %s has data for the undeclared key %q", n.ID, d.Key)
+			}
+			attrs[names[d.Key]] = d.Value
+		}
+		g.nodes[n.ID] = attrs
+	}
+	return g
+}
+
+func parseGEXF(t *testing.T, data []byte) xmlGraph {
+	var doc struct {
+		Attributes []struct {
+			ID    string `xml:"id,attr"`
+			Title string `xml:"title,attr"`
+		} `xml:"graph>attributes>attribute"`
+		Nodes []struct {
+			ID     string `xml:"id,attr"`
+			Label  string `xml:"label,attr"`
+			Values []struct {
+				For   string `xml:"for,attr"`
+				Value string `xml:"value,attr"`
+			} `xml:"attvalues>attvalue"`
+		} `xml:"graph>nodes>node"`
+		Edges []xmlEdge `xml:"graph>edges>edge"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	titles := make(map[string]string)
+	for _, a := range doc.Attributes {
+		titles[a.ID] = a.Title
+	}
+	g := xmlGraph{nodes: make(map[string]map[string]string), edges: doc.Edges}
+	for _, n := range doc.Nodes {
+		if _, ok := g.nodes[n.ID]; ok {
+			t.Fatalf("node %s written twice", n.ID)
+		}
+		if n.Label == "" {
+			t.Errorf("node %s has no label", n.ID)
+		}
+		attrs := make(map[string]string)
+		for _, v := range n.Values {
+			if titles[v.For] == "" {
+				t.Fatalf("node %s has a value for the undeclared attribute %q", n.ID, v.For)
+			}
+			attrs[titles[v.For]] = v.Value
+		}
+		g.nodes[n.ID] = attrs
+	}
+	return g
+}
+
+// checkXMLGraph compares g to the objects of h that keep accepts, and to the
+// distinct references between them.
+func checkXMLGraph(t *testing.T, format string, h *rubyobj.Heap, keep func(*rubyobj.RubyObject) bool, g xmlGraph, edgeID func(int) string) {
+	kept := func(rObj *rubyobj.RubyObject) bool { return keep == nil || keep(rObj) }
+
+	var wantEdges []xmlEdge
+	nodes := 0
+	for _, rObj := range h.Objects() {
+		if !kept(&rObj) {
+			if _, ok := g.nodes[rubyobj.FormatAddress(rObj.Address)]; ok {
+				t.Errorf("%s: %s wasn't kept but was written", format, rubyobj.FormatAddress(rObj.Address))
+			}
+			continue
+		}
+		nodes++
+		id := rubyobj.FormatAddress(rObj.Address)
+		attrs, ok := g.nodes[id]
+		if !ok {
+			t.Errorf("%s: no node for %s", format, id)
+			continue
+		}
+		want := map[string]string{"type": rObj.Type.Name()}
+		if rObj.Memsize != 0 {
+			want["memsize"] = strconv.FormatUint(rObj.Memsize, 10)
+		}
+		if rObj.Class != 0 {
+			want["class_name"] = h.ClassName(&rObj)
+		}
+		if h.IsRoot(rObj.Address) {
+			want["root"] = "true"
+		}
+		if rObj.Value != nil && rObj.Value != "" {
+			want["value"] = fmt.Sprintf("%v", rObj.Value)
+		}
+		for name, v := range want {
+			if attrs[name] != v {
+				t.Errorf("%s: want %s of %s %q, got %q", format, name, id, v, attrs[name])
+			}
+		}
+		for name, v := range attrs {
+			if v == "" || v == "0" || v == "false" {
+				t.Errorf("%s: %s of %s written while unset: %q", format, name, id, v)
+			}
+		}
+
+		seen := make(map[uint64]bool)
+		for _, to := range rObj.References {
+			target, ok := h.Lookup(to)
+			if seen[to] || !ok || !kept(target) {
+				continue
+			}
+			seen[to] = true
+			wantEdges = append(wantEdges, xmlEdge{edgeID(len(wantEdges)), id, rubyobj.FormatAddress(to)})
+		}
+	}
+	if len(g.nodes) != nodes {
+		t.Errorf("%s: want %d nodes, got %d", format, nodes, len(g.nodes))
+	}
+	if len(g.edges) != len(wantEdges) {
+		t.Fatalf("%s: want %d edges, got %d", format, len(wantEdges), len(g.edges))
+	}
+	for i := range wantEdges {
+		if g.edges[i] != wantEdges[i] {
+			t.Errorf("%s: want edge %+v, got %+v", format, wantEdges[i], g.edges[i])
+		}
+	}
+}
+
+func TestWriteGraphXML(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/tiny.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Markup in a class name and a value, and a reference held twice.
+	data = append(data, `{"address":"0x7fc96c0000e1", "type":"CLASS", "name":"A<B>&\"C\""}
+{"address":"0x7fc96c0000e2", "type":"STRING", "class":"0x7fc96c0000e1", "value":"<p class='x'>&amp;</p>", "references":["0x7fc96c0000e1", "0x7fc96c0000e1"], "memsize":40}
+`...)
+	h, err := rubyobj.LoadHeap(bytes.NewReader(data), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formats := []struct {
+		name   string
+		write  func(w *bytes.Buffer, h *rubyobj.Heap, keep func(*rubyobj.RubyObject) bool) error
+		parse  func(t *testing.T, data []byte) xmlGraph
+		edgeID func(int) string
+	}{
+		{"GraphML", func(w *bytes.Buffer, h *rubyobj.Heap, keep func(*rubyobj.RubyObject) bool) error {
+			return rubyobj.WriteGraphML(w, h, keep)
+		}, parseGraphML, func(i int) string { return fmt.Sprintf("e%d", i) }},
+		{"GEXF", func(w *bytes.Buffer, h *rubyobj.Heap, keep func(*rubyobj.RubyObject) bool) error {
+			return rubyobj.WriteGEXF(w, h, keep)
+		}, parseGEXF, strconv.Itoa},
+	}
+	keeps := []struct {
+		name string
+		keep func(*rubyobj.RubyObject) bool
+	}{
+		{"all", nil},
+		{"no strings", func(rObj *rubyobj.RubyObject) bool { return rObj.Type != rubyobj.String }},
+	}
+	for _, f := range formats {
+		for _, k := range keeps {
+			out := bytes.NewBuffer(nil)
+			if err := f.write(out, h, k.keep); err != nil {
+				t.Fatal(err)
+			}
+			g := f.parse(t, out.Bytes())
+			checkXMLGraph(t, f.name+" "+k.name, h, k.keep, g, f.edgeID)
+
+			str, ok := g.nodes["0x7fc96c0000e2"]
+			if k.keep != nil {
+				if ok {
+					t.Errorf("%s: the string wasn't filtered out", f.name)
+				}
+				continue
+			}
+			if !ok || str["class_name"] != `A<B>&"C"` || str["value"] != "<p class='x'>&amp;</p>" {
+				t.Errorf("%s: want the markup read back unchanged, got %q", f.name, str)
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package rubyobj
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultPageSize is how many items are listed when no limit is given.
+const defaultPageSize = 1000
+
+// NewHandler serves a REST API over h:
+//
+//	GET /objects/{addr}             the object at addr
+//	GET /objects/{addr}/references  the objects it references
+//	GET /objects/{addr}/referrers   the objects referencing it
+//	GET /paths/{addr}               a shortest path from a root to addr, see
+//	                                Heap.PathFromRoot
+//	GET /classes                    the number of instances of each class,
+//	                                see Heap.Histogram
+//	GET /query?q={query}            the objects selected by a Query
+//
+// Objects are written like in the dump, as by an Encoder.  Lists are
+// streamed as newline delimited JSON, one item per line, and paginated with
+// the offset and limit parameters; limit defaults to 1000, and the
+// X-Total-Count header holds the number of items of all the pages.
+func NewHandler(h *Heap) http.Handler {
+	s := &heapHandler{h: h, engine: NewQueryEngine(h)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/objects/", s.objects)
+	mux.HandleFunc("/paths/", s.paths)
+	mux.HandleFunc("/classes", s.classes)
+	mux.HandleFunc("/query", s.query)
+	return mux
+}
+
+type heapHandler struct {
+	h      *Heap
+	engine *QueryEngine
+}
+
+func (s *heapHandler) objects(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/objects/"), "/")
+	if len(path) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+	rObj, ok := s.lookup(w, path[0])
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		w.Header().Set("Content-Type", "application/json")
+		NewEncoder(w).Encode(rObj)
+		return
+	}
+	switch path[1] {
+	case "references":
+		s.writeObjects(w, r, rObj.References)
+	case "referrers":
+		s.writeObjects(w, r, s.h.Referrers(rObj.Address))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *heapHandler) paths(w http.ResponseWriter, r *http.Request) {
+	rObj, ok := s.lookup(w, strings.TrimPrefix(r.URL.Path, "/paths/"))
+	if !ok {
+		return
+	}
+	path := s.h.PathFromRoot(rObj.Address)
+	if path == nil {
+		http.Error(w, "object isn't reachable from the roots", http.StatusNotFound)
+		return
+	}
+	s.writeObjects(w, r, path)
+}
+
+func (s *heapHandler) classes(w http.ResponseWriter, r *http.Request) {
+	addrs := make([]uint64, len(s.h.objects))
+	for i := range s.h.objects {
+		addrs[i] = s.h.objects[i].Address
+	}
+	hist := s.h.Histogram(addrs)
+
+	start, end, ok := page(w, r, len(hist))
+	if !ok {
+		return
+	}
+	enc := json.NewEncoder(w)
+	for _, cc := range hist[start:end] {
+		err := enc.Encode(struct {
+			Class   string `json:"class"`
+			Count   uint64 `json:"count"`
+			Memsize uint64 `json:"memsize"`
+		}{cc.Class, cc.Count, cc.Memsize})
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *heapHandler) query(w http.ResponseWriter, r *http.Request) {
+	addrs, err := s.engine.Query(r.FormValue("q"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.writeObjects(w, r, addrs)
+}
+
+// lookup finds the object at addr, replying with an error if there is none.
+func (s *heapHandler) lookup(w http.ResponseWriter, addr string) (*RubyObject, bool) {
+	a, err := ParseAddress(addr)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad address %q", addr), http.StatusBadRequest)
+		return nil, false
+	}
+	rObj, ok := s.h.Lookup(a)
+	if !ok {
+		http.Error(w, "no object at address "+FormatAddress(a), http.StatusNotFound)
+		return nil, false
+	}
+	return rObj, true
+}
+
+// writeObjects writes a page of the objects at addrs.  Addresses of objects
+// missing from the heap are skipped, before paging so that pages are full
+// and the total count is right.
+func (s *heapHandler) writeObjects(w http.ResponseWriter, r *http.Request, addrs []uint64) {
+	objs := make([]*RubyObject, 0, len(addrs))
+	for _, addr := range addrs {
+		if rObj, ok := s.h.Lookup(addr); ok {
+			objs = append(objs, rObj)
+		}
+	}
+	start, end, ok := page(w, r, len(objs))
+	if !ok {
+		return
+	}
+	enc := NewEncoder(w)
+	for _, rObj := range objs[start:end] {
+		if err := enc.Encode(rObj); err != nil {
+			return
+		}
+	}
+}
+
+// page parses the offset and limit parameters into the bounds of a page of
+// a list of total items, and writes the headers of the response.  It replies
+// with an error if they're invalid.
+func page(w http.ResponseWriter, r *http.Request, total int) (start, end int, ok bool) {
+	var err error
+	param := func(name string, def int) int {
+		if err != nil || r.FormValue(name) == "" {
+			return def
+		}
+		var n int
+		n, err = strconv.Atoi(r.FormValue(name))
+		if err == nil && n < 0 {
+			err = fmt.Errorf("negative %s", name)
+		}
+		return n
+	}
+	offset := param("offset", 0)
+	limit := param("limit", defaultPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	start, end = total, total
+	if offset < total {
+		start = offset
+	}
+	if limit < end-start {
+		end = start + limit
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	return start, end, true
+}
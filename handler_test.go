@@ -0,0 +1,236 @@
+package rubyobj_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/aybabtme/rubyobj"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, *rubyobj.Heap) {
+	f, err := os.Open("testdata/tiny.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	h, err := rubyobj.LoadHeap(f, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(rubyobj.NewHandler(h))
+	t.Cleanup(ts.Close)
+	return ts, h
+}
+
+func get(t *testing.T, ts *httptest.Server, path string, wantStatus int) *http.Response {
+	resp, err := http.Get(ts.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != wantStatus {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("GET %s: want status %d, got %d: %s", path, wantStatus, resp.StatusCode, body)
+	}
+	return resp
+}
+
+// decodeResponse decodes the NDJSON objects of a response.
+func decodeResponse(t *testing.T, resp *http.Response) []rubyobj.RubyObject {
+	var objs []rubyobj.RubyObject
+	dec := rubyobj.NewDecoder(resp.Body)
+	for {
+		var rObj rubyobj.RubyObject
+		err := dec.Decode(&rObj)
+		if err == io.EOF {
+			return objs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		objs = append(objs, rObj)
+	}
+}
+
+func totalCount(t *testing.T, resp *http.Response) int {
+	n, err := strconv.Atoi(resp.Header.Get("X-Total-Count"))
+	if err != nil {
+		t.Fatalf("bad X-Total-Count: %v", err)
+	}
+	return n
+}
+
+func TestHandlerObject(t *testing.T) {
+	ts, h := newTestServer(t)
+	want := h.Objects()[0]
+
+	resp := get(t, ts, "/objects/"+rubyobj.FormatAddress(want.Address), http.StatusOK)
+	objs := decodeResponse(t, resp)
+	if len(objs) != 1 {
+		t.Fatalf("want 1 object, got %d", len(objs))
+	}
+	if objs[0].Address != want.Address || objs[0].Type != want.Type || objs[0].Memsize != want.Memsize {
+		t.Errorf("want %+v, got %+v", want, objs[0])
+	}
+
+	get(t, ts, "/objects/0x1", http.StatusNotFound)
+	get(t, ts, "/objects/nope", http.StatusBadRequest)
+	get(t, ts, "/objects/"+rubyobj.FormatAddress(want.Address)+"/nope", http.StatusNotFound)
+}
+
+func TestHandlerReferencesAndReferrers(t *testing.T) {
+	ts, h := newTestServer(t)
+
+	for _, rObj := range h.Objects() {
+		addr := rubyobj.FormatAddress(rObj.Address)
+
+		resp := get(t, ts, "/objects/"+addr+"/referrers", http.StatusOK)
+		if got, want := totalCount(t, resp), len(h.Referrers(rObj.Address)); got != want {
+			t.Errorf("%s: want %d referrers, got %d", addr, want, got)
+		}
+		for _, from := range decodeResponse(t, resp) {
+			found := false
+			for _, to := range from.References {
+				found = found || to == rObj.Address
+			}
+			if !found {
+				t.Errorf("%s: referrer %s doesn't reference it", addr, rubyobj.FormatAddress(from.Address))
+			}
+		}
+
+		var want int
+		for _, to := range rObj.References {
+			if _, ok := h.Lookup(to); ok {
+				want++
+			}
+		}
+		resp = get(t, ts, "/objects/"+addr+"/references", http.StatusOK)
+		if got := totalCount(t, resp); got != want {
+			t.Errorf("%s: want %d references, got %d", addr, want, got)
+		}
+	}
+}
+
+func TestHandlerMissingReferences(t *testing.T) {
+	h := loadHeap(t, `{"address":"0x7fc96c000001", "type":"ARRAY", "references":["0x7fc96c000009", "0x7fc96c000002", "0x7fc96c00000a", "0x7fc96c000003", "0x7fc96c000004"]}
+{"address":"0x7fc96c000002", "type":"OBJECT"}
+{"address":"0x7fc96c000003", "type":"OBJECT"}
+{"address":"0x7fc96c000004", "type":"OBJECT"}
+`)
+	ts := httptest.NewServer(rubyobj.NewHandler(h))
+	defer ts.Close()
+
+	// The missing objects are neither counted nor leave holes in pages.
+	pages := [][]uint64{
+		{0x7fc96c000002, 0x7fc96c000003},
+		{0x7fc96c000004},
+		nil,
+	}
+	for i, want := range pages {
+		resp := get(t, ts, "/objects/0x7fc96c000001/references?limit=2&offset="+strconv.Itoa(2*i), http.StatusOK)
+		if n := totalCount(t, resp); n != 3 {
+			t.Errorf("want a total of 3 references, got %d", n)
+		}
+		objs := decodeResponse(t, resp)
+		if len(objs) != len(want) {
+			t.Fatalf("page %d: want %x, got %+v", i, want, objs)
+		}
+		for j := range want {
+			if objs[j].Address != want[j] {
+				t.Errorf("page %d: want %x, got %+v", i, want, objs)
+			}
+		}
+	}
+}
+
+func TestHandlerPaths(t *testing.T) {
+	ts, h := newTestServer(t)
+
+	var reached int
+	for _, rObj := range h.Objects() {
+		addr := rubyobj.FormatAddress(rObj.Address)
+		path := h.PathFromRoot(rObj.Address)
+		if path == nil {
+			get(t, ts, "/paths/"+addr, http.StatusNotFound)
+			continue
+		}
+		reached++
+
+		objs := decodeResponse(t, get(t, ts, "/paths/"+addr, http.StatusOK))
+		if len(objs) != len(path) {
+			t.Fatalf("%s: want a path of %d objects, got %d", addr, len(path), len(objs))
+		}
+		if !h.IsRoot(objs[0].Address) {
+			t.Errorf("%s: path doesn't start at a root", addr)
+		}
+		if objs[len(objs)-1].Address != rObj.Address {
+			t.Errorf("%s: path doesn't end at the object", addr)
+		}
+	}
+	if reached == 0 {
+		t.Error("no object reachable from the roots")
+	}
+}
+
+func TestHandlerClasses(t *testing.T) {
+	ts, h := newTestServer(t)
+
+	resp := get(t, ts, "/classes", http.StatusOK)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("want NDJSON, got %q", ct)
+	}
+
+	var total uint64
+	scan := bufio.NewScanner(resp.Body)
+	for scan.Scan() {
+		var cc struct {
+			Class string `json:"class"`
+			Count uint64 `json:"count"`
+		}
+		if err := json.Unmarshal(scan.Bytes(), &cc); err != nil {
+			t.Fatal(err)
+		}
+		if cc.Class == "" {
+			t.Errorf("class without a name: %s", scan.Text())
+		}
+		total += cc.Count
+	}
+	if total != uint64(h.Len()) {
+		t.Errorf("want %d objects counted, got %d", h.Len(), total)
+	}
+}
+
+func TestHandlerQueryPagination(t *testing.T) {
+	ts, h := newTestServer(t)
+
+	all := decodeResponse(t, get(t, ts, "/query?q=all", http.StatusOK))
+	if len(all) != h.Len() {
+		t.Fatalf("want %d objects, got %d", h.Len(), len(all))
+	}
+
+	var paged []rubyobj.RubyObject
+	for offset := 0; offset < len(all)+3; offset += 3 {
+		resp := get(t, ts, "/query?q=all&limit=3&offset="+strconv.Itoa(offset), http.StatusOK)
+		if n := totalCount(t, resp); n != len(all) {
+			t.Fatalf("want a total of %d, got %d", len(all), n)
+		}
+		paged = append(paged, decodeResponse(t, resp)...)
+	}
+	if len(paged) != len(all) {
+		t.Fatalf("want %d objects over all pages, got %d", len(all), len(paged))
+	}
+	for i := range all {
+		if paged[i].Address != all[i].Address {
+			t.Fatalf("object %d: want %x, got %x", i, all[i].Address, paged[i].Address)
+		}
+	}
+
+	get(t, ts, "/query?q=all&limit=-1", http.StatusBadRequest)
+	get(t, ts, "/query?q=all&offset=x", http.StatusBadRequest)
+	get(t, ts, "/query?q=bogus", http.StatusBadRequest)
+}
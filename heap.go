@@ -0,0 +1,233 @@
+package rubyobj
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Heap is an ObjectSpace dump loaded in memory and indexed by address, for
+// analyses that need to follow references between objects.
+type Heap struct {
+	objects []RubyObject
+	roots   []RubyObject
+	index   map[uint64]int
+	classes classNames
+
+	referrersOnce sync.Once
+	referrers     map[uint64][]uint64
+
+	rootedOnce sync.Once
+	rooted     map[uint64]bool
+}
+
+// NewHeap returns an empty heap, ready to be filled with Add.
+func NewHeap() *Heap {
+	return &Heap{
+		index:   make(map[uint64]int),
+		classes: make(classNames),
+	}
+}
+
+// LoadHeap decodes all the objects in r using ParallelDecode with para
+// decoders.  Objects that fail to decode are skipped; if any did, the heap is
+// returned along with an error describing the first failure.
+func LoadHeap(r io.Reader, para uint) (*Heap, error) {
+	h := NewHeap()
+
+	objC, errC := ParallelDecode(r, para)
+
+	var errCount int
+	var firstErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for err := range errC {
+			if firstErr == nil {
+				firstErr = err
+			}
+			errCount++
+		}
+	}()
+
+	for obj := range objC {
+		h.Add(&obj)
+	}
+	<-done
+
+	if errCount != 0 {
+		return h, fmt.Errorf("got %d errors decoding heap, first was: %v", errCount, firstErr)
+	}
+	return h, nil
+}
+
+// Add copies rObj into the heap.  It must not be called once the heap is
+// being queried.
+func (h *Heap) Add(rObj *RubyObject) {
+	if rObj.Type == Root {
+		h.roots = append(h.roots, *rObj)
+		return
+	}
+	h.classes.observe(rObj)
+	h.index[rObj.Address] = len(h.objects)
+	h.objects = append(h.objects, *rObj)
+}
+
+// Len is the number of objects in the heap, not counting ROOT records.
+func (h *Heap) Len() int { return len(h.objects) }
+
+// Objects are all the objects of the heap, in the order they were added.  The
+// slice must not be modified.
+func (h *Heap) Objects() []RubyObject { return h.objects }
+
+// Roots are the ROOT records of the heap.  Their references are the objects
+// directly held by the VM.
+func (h *Heap) Roots() []RubyObject { return h.roots }
+
+// Lookup finds the object at addr.
+func (h *Heap) Lookup(addr uint64) (*RubyObject, bool) {
+	i, ok := h.index[addr]
+	if !ok {
+		return nil, false
+	}
+	return &h.objects[i], true
+}
+
+// ClassName is the name of the class of rObj, or the address of the class
+// if it has no name.
+func (h *Heap) ClassName(rObj *RubyObject) string {
+	return h.classes.name(rObj.Class)
+}
+
+// Referrers are the addresses of the objects holding a reference to addr.
+// ROOT records are not included, see Roots.
+func (h *Heap) Referrers(addr uint64) []uint64 {
+	h.referrersOnce.Do(h.buildReferrers)
+	return h.referrers[addr]
+}
+
+func (h *Heap) buildReferrers() {
+	h.referrers = make(map[uint64][]uint64, len(h.objects))
+	for i := range h.objects {
+		from := h.objects[i].Address
+		for _, to := range h.objects[i].References {
+			h.referrers[to] = append(h.referrers[to], from)
+		}
+	}
+}
+
+// IsRoot tells if the object at addr is directly referenced by a ROOT record.
+func (h *Heap) IsRoot(addr uint64) bool {
+	h.rootedOnce.Do(func() {
+		h.rooted = make(map[uint64]bool)
+		for _, root := range h.roots {
+			for _, to := range root.References {
+				h.rooted[to] = true
+			}
+		}
+	})
+	return h.rooted[addr]
+}
+
+// Instances are the addresses of the objects whose class is named class.
+func (h *Heap) Instances(class string) []uint64 {
+	var addrs []uint64
+	for i := range h.objects {
+		if h.ClassName(&h.objects[i]) == class {
+			addrs = append(addrs, h.objects[i].Address)
+		}
+	}
+	return addrs
+}
+
+// ClassNames are the names of the classes and modules of the heap, sorted.
+func (h *Heap) ClassNames() []string {
+	seen := make(map[string]bool, len(h.classes))
+	names := make([]string, 0, len(h.classes))
+	for _, name := range h.classes {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Histogram counts the objects at addrs by class, the most numerous first.
+func (h *Heap) Histogram(addrs []uint64) []ClassCount {
+	counts := make(map[string]*ClassCount)
+	for _, addr := range addrs {
+		rObj, ok := h.Lookup(addr)
+		if !ok {
+			continue
+		}
+		name := h.ClassName(rObj)
+		cc, ok := counts[name]
+		if !ok {
+			cc = &ClassCount{Class: name}
+			counts[name] = cc
+		}
+		cc.Count++
+		cc.Memsize += rObj.Memsize
+	}
+	out := make([]ClassCount, 0, len(counts))
+	for _, cc := range counts {
+		out = append(out, *cc)
+	}
+	sort.Sort(byClassCount(out))
+	return out
+}
+
+// RootCategories are the categories of the ROOT records referencing the
+// object at addr, like "vm" or "global_tbl".
+func (h *Heap) RootCategories(addr uint64) []string {
+	if !h.IsRoot(addr) {
+		return nil
+	}
+	var cats []string
+	for _, root := range h.roots {
+		for _, to := range root.References {
+			if to == addr {
+				cats = append(cats, root.Root)
+				break
+			}
+		}
+	}
+	return cats
+}
+
+// PathFromRoot is one of the shortest chains of references keeping the
+// object at addr alive: it starts with an object referenced by a ROOT record
+// and ends with addr.  It's nil if addr can't be reached from the roots.
+func (h *Heap) PathFromRoot(addr uint64) []uint64 {
+	if _, ok := h.Lookup(addr); !ok {
+		return nil
+	}
+	// Walk the referrers breadth first, remembering for each object the
+	// object it was reached from, which it references.
+	next := map[uint64]uint64{addr: 0}
+	frontier := []uint64{addr}
+	for len(frontier) != 0 {
+		var nextFrontier []uint64
+		for _, to := range frontier {
+			if h.IsRoot(to) {
+				path := []uint64{to}
+				for to != addr {
+					to = next[to]
+					path = append(path, to)
+				}
+				return path
+			}
+			for _, from := range h.Referrers(to) {
+				if _, seen := next[from]; !seen {
+					next[from] = to
+					nextFrontier = append(nextFrontier, from)
+				}
+			}
+		}
+		frontier = nextFrontier
+	}
+	return nil
+}
@@ -0,0 +1,510 @@
+package rubyobj
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// The index file starts with a header, followed by sections whose sizes
+// are given by the header.  All integers are little endian uint64:
+//
+//	header:    magic, dump size, dump modification time (ns), objects,
+//	           references, classes, length of the names, unused
+//	objects:   address, offset, length, class; sorted by address
+//	refStart:  objects+1 positions in the referrers section, where the
+//	           referrers of each object start
+//	referrers: addresses of the referrers of each object
+//	classes:   name offset, name length, first and last+1 positions in the
+//	           instances section; sorted by name
+//	instances: addresses of the instances of each class
+//	names:     class names, one after the other
+const (
+	indexMagic      = "RBOBJIX1"
+	indexHeaderSize = 8 * 8
+	indexEntrySize  = 4 * 8
+	indexClassSize  = 4 * 8
+)
+
+// IndexPath is the path of the sidecar index of the dump at path.
+func IndexPath(path string) string { return path + ".idx" }
+
+// Index is a sidecar index of a dump file, which answers lookups without
+// decoding the dump: the byte offset of the line of each object, the
+// referrers of each object and the instances of each class.  It's
+// memory-mapped where the OS allows it.
+type Index struct {
+	data    []byte
+	release func() error
+
+	objects   []byte
+	refStart  []byte
+	referrers []byte
+	classes   []byte
+	instances []byte
+	names     []byte
+}
+
+// OpenIndex opens the sidecar index of the dump at path, building it with
+// para decoders if it doesn't exist or if the dump changed since.  Objects
+// that fail to decode are left out of the index; if any did, the index is
+// returned along with an error describing the first failure.
+func OpenIndex(path string, para uint) (*Index, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := openIndexFile(IndexPath(path))
+	if err == nil && idx.matches(fi) {
+		return idx, nil
+	}
+	if idx != nil {
+		idx.Close()
+	}
+
+	buildErr := BuildIndex(path, para)
+	if buildErr != nil && !isDecodeErrors(buildErr) {
+		return nil, buildErr
+	}
+	idx, err = openIndexFile(IndexPath(path))
+	if err != nil {
+		return nil, err
+	}
+	return idx, buildErr
+}
+
+// decodeErrors is returned when some lines of a dump failed to decode.
+type decodeErrors struct {
+	count int
+	first error
+}
+
+func (d *decodeErrors) Error() string {
+	return fmt.Sprintf("got %d errors decoding dump, first was: %v", d.count, d.first)
+}
+
+func isDecodeErrors(err error) bool {
+	_, ok := err.(*decodeErrors)
+	return ok
+}
+
+func openIndexFile(path string) (*Index, error) {
+	data, release, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	idx := &Index{data: data, release: release}
+	if err := idx.parse(); err != nil {
+		release()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return idx, nil
+}
+
+func (idx *Index) header(i int) uint64 {
+	return binary.LittleEndian.Uint64(idx.data[8*i:])
+}
+
+func (idx *Index) parse() error {
+	if len(idx.data) < indexHeaderSize || string(idx.data[:8]) != indexMagic {
+		return fmt.Errorf("not an index file")
+	}
+	objects, refs, classes, names := idx.header(3), idx.header(4), idx.header(5), idx.header(6)
+	if size := uint64(len(idx.data)); objects > size || refs > size || classes > size || names > size {
+		return fmt.Errorf("truncated or corrupted index")
+	}
+
+	rest := idx.data[indexHeaderSize:]
+	section := func(size uint64) []byte {
+		if rest == nil || uint64(len(rest)) < size {
+			rest = nil
+			return nil
+		}
+		s := rest[:size]
+		rest = rest[size:]
+		return s
+	}
+	idx.objects = section(objects * indexEntrySize)
+	idx.refStart = section((objects + 1) * 8)
+	idx.referrers = section(refs * 8)
+	idx.classes = section(classes * indexClassSize)
+	idx.instances = section(objects * 8)
+	idx.names = section(names)
+	if rest == nil || len(rest) != 0 {
+		return fmt.Errorf("truncated or corrupted index")
+	}
+	return idx.check()
+}
+
+// check verifies that the positions the index reads in its own sections are
+// in them, so that lookups can't go out of bounds.
+func (idx *Index) check() error {
+	n := idx.Len()
+	for i := 1; i < n; i++ {
+		if uint64At(idx.objects, 4*i) < uint64At(idx.objects, 4*(i-1)) {
+			return fmt.Errorf("objects aren't sorted by address")
+		}
+	}
+	refs := uint64(len(idx.referrers) / 8)
+	if uint64At(idx.refStart, 0) != 0 || uint64At(idx.refStart, n) != refs {
+		return fmt.Errorf("referrers don't cover the referrers section")
+	}
+	for i := 1; i <= n; i++ {
+		if uint64At(idx.refStart, i) < uint64At(idx.refStart, i-1) {
+			return fmt.Errorf("referrers of object %d start before those of object %d", i, i-1)
+		}
+	}
+	names, instances := uint64(len(idx.names)), uint64(len(idx.instances)/8)
+	for i := 0; i < len(idx.classes)/indexClassSize; i++ {
+		off, length := uint64At(idx.classes, 4*i), uint64At(idx.classes, 4*i+1)
+		if off > names || length > names-off {
+			return fmt.Errorf("name of class %d is out of the names section", i)
+		}
+		first, last := uint64At(idx.classes, 4*i+2), uint64At(idx.classes, 4*i+3)
+		if first > last || last > instances {
+			return fmt.Errorf("instances of class %d are out of the instances section", i)
+		}
+	}
+	return nil
+}
+
+// matches tells if the index was built from the dump described by fi.
+func (idx *Index) matches(fi os.FileInfo) bool {
+	return idx.header(1) == uint64(fi.Size()) && idx.header(2) == uint64(fi.ModTime().UnixNano())
+}
+
+// Close releases the index.  Slices returned by the index must not be used
+// afterwards.
+func (idx *Index) Close() error {
+	return idx.release()
+}
+
+func uint64At(b []byte, i int) uint64 {
+	return binary.LittleEndian.Uint64(b[8*i:])
+}
+
+// Len is the number of objects in the index, not counting ROOT records.
+func (idx *Index) Len() int { return len(idx.objects) / indexEntrySize }
+
+// find is the position of addr in the objects section.
+func (idx *Index) find(addr uint64) (int, bool) {
+	n := idx.Len()
+	i := sort.Search(n, func(i int) bool { return uint64At(idx.objects, 4*i) >= addr })
+	return i, i < n && uint64At(idx.objects, 4*i) == addr
+}
+
+// Offset is where the line of the object at addr starts in the dump, and
+// its length, newline included.
+func (idx *Index) Offset(addr uint64) (offset, length int64, ok bool) {
+	i, ok := idx.find(addr)
+	if !ok {
+		return 0, 0, false
+	}
+	return int64(uint64At(idx.objects, 4*i+1)), int64(uint64At(idx.objects, 4*i+2)), true
+}
+
+// Class is the address of the class of the object at addr.
+func (idx *Index) Class(addr uint64) (uint64, bool) {
+	i, ok := idx.find(addr)
+	if !ok {
+		return 0, false
+	}
+	return uint64At(idx.objects, 4*i+3), true
+}
+
+// Referrers are the addresses of the objects holding a reference to addr.
+func (idx *Index) Referrers(addr uint64) []uint64 {
+	i, ok := idx.find(addr)
+	if !ok {
+		return nil
+	}
+	return readUint64s(idx.referrers, int(uint64At(idx.refStart, i)), int(uint64At(idx.refStart, i+1)))
+}
+
+// ClassNames are the names of the classes with instances in the dump,
+// sorted.  Anonymous classes are named after their address.
+func (idx *Index) ClassNames() []string {
+	names := make([]string, len(idx.classes)/indexClassSize)
+	for i := range names {
+		names[i] = idx.className(i)
+	}
+	return names
+}
+
+func (idx *Index) className(i int) string {
+	off, n := uint64At(idx.classes, 4*i), uint64At(idx.classes, 4*i+1)
+	return string(idx.names[off : off+n])
+}
+
+// Instances are the addresses of the objects whose class is named class,
+// sorted.
+func (idx *Index) Instances(class string) []uint64 {
+	n := len(idx.classes) / indexClassSize
+	i := sort.Search(n, func(i int) bool { return idx.className(i) >= class })
+	if i == n || idx.className(i) != class {
+		return nil
+	}
+	return readUint64s(idx.instances, int(uint64At(idx.classes, 4*i+2)), int(uint64At(idx.classes, 4*i+3)))
+}
+
+func readUint64s(b []byte, from, to int) []uint64 {
+	out := make([]uint64, to-from)
+	for i := range out {
+		out[i] = uint64At(b, from+i)
+	}
+	return out
+}
+
+// Building
+
+type indexEntry struct {
+	addr, offset, length, class uint64
+}
+
+type indexEdge struct {
+	to, from uint64
+}
+
+type byEntryAddress []indexEntry
+
+func (b byEntryAddress) Len() int           { return len(b) }
+func (b byEntryAddress) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byEntryAddress) Less(i, j int) bool { return b[i].addr < b[j].addr }
+
+type byEdge []indexEdge
+
+func (b byEdge) Len() int      { return len(b) }
+func (b byEdge) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byEdge) Less(i, j int) bool {
+	if b[i].to != b[j].to {
+		return b[i].to < b[j].to
+	}
+	return b[i].from < b[j].from
+}
+
+// BuildIndex decodes the dump at path with para decoders and writes its
+// sidecar index, replacing any existing one.
+func BuildIndex(path string, para uint) error {
+	fr, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+	fi, err := fr.Stat()
+	if err != nil {
+		return err
+	}
+
+	var entries []indexEntry
+	var edges []indexEdge
+	classes := make(classNames)
+	decodeErr := decodeLinesAt(fr, para, func(offset, length int64, rObj *RubyObject) {
+		if rObj.Type == Root {
+			return
+		}
+		classes.observe(rObj)
+		entries = append(entries, indexEntry{rObj.Address, uint64(offset), uint64(length), rObj.Class})
+		for _, to := range rObj.References {
+			edges = append(edges, indexEdge{to, rObj.Address})
+		}
+	})
+	if decodeErr != nil && !isDecodeErrors(decodeErr) {
+		return decodeErr
+	}
+
+	tmp := IndexPath(path) + ".tmp"
+	fw, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = writeIndex(fw, fi, entries, edges, classes)
+	if cerr := fw.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, IndexPath(path))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return decodeErr
+}
+
+func writeIndex(w io.Writer, fi os.FileInfo, entries []indexEntry, edges []indexEdge, classes classNames) error {
+	sort.Sort(byEntryAddress(entries))
+	sort.Sort(byEdge(edges))
+
+	// Group the instances by class name.
+	byClass := make(map[string][]uint64)
+	for _, e := range entries {
+		name := classes.name(e.class)
+		byClass[name] = append(byClass[name], e.addr)
+	}
+	names := make([]string, 0, len(byClass))
+	var namesLen int
+	for name := range byClass {
+		names = append(names, name)
+		namesLen += len(name)
+	}
+	sort.Strings(names)
+
+	// Only keep the references between objects of the dump.
+	var refs int
+	for _, e := range edges {
+		if _, ok := findEntry(entries, e.to); ok {
+			refs++
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	var buf [8]byte
+	put := func(v uint64) {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		bw.Write(buf[:])
+	}
+
+	bw.WriteString(indexMagic)
+	put(uint64(fi.Size()))
+	put(uint64(fi.ModTime().UnixNano()))
+	put(uint64(len(entries)))
+	put(uint64(refs))
+	put(uint64(len(names)))
+	put(uint64(namesLen))
+	put(0)
+
+	for _, e := range entries {
+		put(e.addr)
+		put(e.offset)
+		put(e.length)
+		put(e.class)
+	}
+
+	// Edges are sorted like entries, so the referrers of each entry are
+	// found by walking both together.
+	var pos uint64
+	j := 0
+	for _, e := range entries {
+		put(pos)
+		for j < len(edges) && edges[j].to < e.addr {
+			j++
+		}
+		for ; j < len(edges) && edges[j].to == e.addr; j++ {
+			pos++
+		}
+	}
+	put(pos)
+	for _, e := range edges {
+		if _, ok := findEntry(entries, e.to); ok {
+			put(e.from)
+		}
+	}
+
+	var nameOff, instOff uint64
+	for _, name := range names {
+		n := uint64(len(byClass[name]))
+		put(nameOff)
+		put(uint64(len(name)))
+		put(instOff)
+		put(instOff + n)
+		nameOff += uint64(len(name))
+		instOff += n
+	}
+	for _, name := range names {
+		for _, addr := range byClass[name] {
+			put(addr)
+		}
+	}
+	for _, name := range names {
+		bw.WriteString(name)
+	}
+	return bw.Flush()
+}
+
+func findEntry(entries []indexEntry, addr uint64) (int, bool) {
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].addr >= addr })
+	return i, i < len(entries) && entries[i].addr == addr
+}
+
+// decodeLinesAt decodes the lines of r with para decoders, and calls fn from
+// the calling goroutine with each object, the offset of its line and the
+// length of the line.  Lines that fail to decode are skipped and reported
+// as a *decodeErrors once r is exhausted.
+func decodeLinesAt(r io.Reader, para uint, fn func(offset, length int64, rObj *RubyObject)) error {
+	type line struct {
+		offset int64
+		data   []byte
+	}
+	type result struct {
+		offset, length int64
+		rObj           RubyObject
+		err            error
+	}
+
+	lineC := make(chan line, para<<2)
+	resultC := make(chan result, para<<2)
+	readErr := make(chan error, 1)
+
+	go func() {
+		defer close(lineC)
+		br := bufio.NewReader(r)
+		var offset int64
+		for {
+			data, err := br.ReadBytes('\n')
+			if len(bytes.TrimSpace(data)) != 0 {
+				lineC <- line{offset, data}
+			}
+			offset += int64(len(data))
+			if err == io.EOF {
+				readErr <- nil
+				return
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := uint(0); i < para; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dec := newLineDecoder()
+			for l := range lineC {
+				res := result{offset: l.offset, length: int64(len(l.data))}
+				res.err = dec.decode(l.data, &res.rObj)
+				resultC <- res
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(resultC)
+	}()
+
+	var errs decodeErrors
+	for res := range resultC {
+		if res.err != nil {
+			if errs.first == nil {
+				errs.first = res.err
+			}
+			errs.count++
+			continue
+		}
+		fn(res.offset, res.length, &res.rObj)
+	}
+	if err := <-readErr; err != nil {
+		return err
+	}
+	if errs.count != 0 {
+		return &errs
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package rubyobj
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// copyDump copies a dump of testdata to a temporary directory, where its
+// index can be written.
+func copyDump(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	path := copyDump(t, "small.json")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := OpenIndex(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if _, err := os.Stat(IndexPath(path)); err != nil {
+		t.Fatalf("the index wasn't written: %v", err)
+	}
+
+	var objs []RubyObject
+	addrs := make(map[uint64]bool)
+	classes := NewClassIndex()
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var rObj RubyObject
+		if err := newLineDecoder().decode([]byte(line), &rObj); err != nil {
+			t.Fatal(err)
+		}
+		if rObj.Type == Root {
+			continue
+		}
+		objs = append(objs, rObj)
+		addrs[rObj.Address] = true
+		classes.Add(&rObj)
+	}
+	if idx.Len() != len(objs) {
+		t.Fatalf("want %d objects in the index, got %d", len(objs), idx.Len())
+	}
+
+	referrers := make(map[uint64][]uint64)
+	instances := make(map[string][]uint64)
+	for _, rObj := range objs {
+		for _, ref := range rObj.References {
+			if addrs[ref] {
+				referrers[ref] = append(referrers[ref], rObj.Address)
+			}
+		}
+		name := classes.ClassName(&rObj)
+		instances[name] = append(instances[name], rObj.Address)
+	}
+
+	for _, rObj := range objs {
+		offset, length, ok := idx.Offset(rObj.Address)
+		if !ok {
+			t.Fatalf("%s isn't in the index", FormatAddress(rObj.Address))
+		}
+		var got RubyObject
+		if err := newLineDecoder().decode(data[offset:offset+length], &got); err != nil || got.Address != rObj.Address {
+			t.Fatalf("line of %s at %d is %q", FormatAddress(rObj.Address), offset, data[offset:offset+length])
+		}
+		if class, _ := idx.Class(rObj.Address); class != rObj.Class {
+			t.Errorf("want class %s for %s, got %s", FormatAddress(rObj.Class), FormatAddress(rObj.Address), FormatAddress(class))
+		}
+		want := referrers[rObj.Address]
+		sort.Sort(uint64s(want))
+		if got := idx.Referrers(rObj.Address); len(got) != len(want) || (len(want) != 0 && !reflect.DeepEqual(got, want)) {
+			t.Errorf("want referrers %x for %s, got %x", want, FormatAddress(rObj.Address), got)
+		}
+	}
+	if _, _, ok := idx.Offset(1); ok {
+		t.Error("found an object at address 1")
+	}
+
+	names := idx.ClassNames()
+	if len(names) != len(instances) || !sort.StringsAreSorted(names) {
+		t.Fatalf("want %d sorted class names, got %v", len(instances), names)
+	}
+	for name, want := range instances {
+		sort.Sort(uint64s(want))
+		if got := idx.Instances(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("want %d instances of %s, got %d", len(want), name, len(got))
+		}
+	}
+	if got := idx.Instances("NoSuchClass"); got != nil {
+		t.Errorf("want no instance of NoSuchClass, got %x", got)
+	}
+}
+
+func TestIndexCorrupted(t *testing.T) {
+	path := copyDump(t, "tiny.json")
+	if err := BuildIndex(path, 2); err != nil {
+		t.Fatal(err)
+	}
+	good, err := ioutil.ReadFile(IndexPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := func(data []byte, i int) uint64 { return binary.LittleEndian.Uint64(data[8*i:]) }
+	objects, refs, classes := header(good, 3), header(good, 4), header(good, 5)
+	if objects < 2 || refs == 0 || classes == 0 {
+		t.Fatalf("tiny.json should have objects, references and classes")
+	}
+	// Where the sections start.
+	refStart := indexHeaderSize + objects*indexEntrySize
+	classStart := refStart + (objects+1)*8 + refs*8
+
+	put := func(off, v uint64) func([]byte) []byte {
+		return func(data []byte) []byte {
+			binary.LittleEndian.PutUint64(data[off:], v)
+			return data
+		}
+	}
+	tests := []struct {
+		name    string
+		corrupt func([]byte) []byte
+	}{
+		{"bad magic", put(0, 0)},
+		{"truncated", func(data []byte) []byte { return data[:len(data)-1] }},
+		{"trailing bytes", func(data []byte) []byte { return append(data, 0) }},
+		{"huge object count", put(8*3, 1<<62)},
+		{"huge names length", put(8*6, 1<<63)},
+		{"unsorted objects", put(indexHeaderSize, 1<<63)},
+		{"referrers not starting at 0", put(refStart, 1)},
+		{"referrers past the end", put(refStart+objects*8, refs+1)},
+		{"referrers going back", put(refStart+8, 1<<40)},
+		{"class name past the end", put(classStart, 1<<40)},
+		{"class name length past the end", put(classStart+8, 1<<63)},
+		{"instances past the end", put(classStart+24, objects+1)},
+		{"instances going back", put(classStart+16, 1<<40)},
+	}
+	for _, tt := range tests {
+		data := tt.corrupt(append([]byte(nil), good...))
+		if err := ioutil.WriteFile(IndexPath(path), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if idx, err := openIndexFile(IndexPath(path)); err == nil {
+			idx.Close()
+			t.Errorf("%s: the index was accepted", tt.name)
+		}
+
+		// OpenIndex rebuilds it instead.
+		idx, err := OpenIndex(path, 2)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if idx.Len() != int(objects) || len(idx.ClassNames()) != int(classes) {
+			t.Errorf("%s: the index wasn't rebuilt", tt.name)
+		}
+		idx.Close()
+	}
+}
@@ -13,6 +13,7 @@ func (f *flagSchema) clear() {
 }
 
 type objectSchema struct {
+	Root       string     `json:"root,omitempty"`
 	Address    string     `json:"address,omitempty"`
 	Class      string     `json:"class,omitempty"`
 	NodeType   string     `json:"node_type,omitempty"`
@@ -43,6 +44,7 @@ type objectSchema struct {
 }
 
 func (o *objectSchema) clear() {
+	o.Root = ""
 	o.Address = ""
 	o.Class = ""
 	o.NodeType = ""
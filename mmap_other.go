@@ -0,0 +1,16 @@
+//go:build !unix
+
+package rubyobj
+
+import (
+	"io/ioutil"
+)
+
+// mapFile reads the file at path in memory, where mmap isn't available.
+func mapFile(path string) ([]byte, func() error, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,177 @@
+package rubyobj
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// ErrNotFound is returned when looking up an address that isn't in a dump.
+var ErrNotFound = errors.New("no object at this address")
+
+// OffsetIndex tells where the line of an object is in a dump: its offset
+// and its length, newline included.  Index is an OffsetIndex.
+type OffsetIndex interface {
+	Offset(addr uint64) (offset, length int64, ok bool)
+}
+
+type offsetMap map[uint64][2]int64
+
+func (m offsetMap) Offset(addr uint64) (offset, length int64, ok bool) {
+	o, ok := m[addr]
+	return o[0], o[1], ok
+}
+
+// BuildOffsetIndex scans the lines of r and indexes them by address, without
+// decoding them.  It's much faster than decoding the dump, but the index
+// lives in memory; see OpenIndex for an index kept on disk.
+func BuildOffsetIndex(r io.Reader) (OffsetIndex, error) {
+	m := make(offsetMap)
+	br := bufio.NewReader(r)
+	var offset int64
+	for {
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Long lines are rare, so only copy them.
+			long := append([]byte(nil), line...)
+			for err == bufio.ErrBufferFull {
+				line, err = br.ReadSlice('\n')
+				long = append(long, line...)
+			}
+			line = long
+		}
+		if addr, ok := lineAddress(line); ok {
+			m[addr] = [2]int64{offset, int64(len(line))}
+		}
+		offset += int64(len(line))
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+var addressKey = []byte(`"address"`)
+
+// lineAddress finds the address member of a line of a dump.  The line is
+// scanned string by string, so that only the "address" key of the top level
+// object counts, not the same word in a value or a nested object.
+func lineAddress(line []byte) (uint64, bool) {
+	depth, isKey := 0, false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '{':
+			depth++
+			isKey = true
+		case '[':
+			depth++
+			isKey = false
+		case '}', ']':
+			depth--
+		case ',':
+			isKey = true
+		case ':':
+			isKey = false
+		case '"':
+			end := stringEnd(line, i)
+			if end < 0 {
+				return 0, false
+			}
+			if depth == 1 && isKey && bytes.Equal(line[i:end+1], addressKey) {
+				return memberAddress(line[end+1:])
+			}
+			i = end
+		}
+	}
+	return 0, false
+}
+
+// stringEnd is the index of the quote closing the string opened at i.
+func stringEnd(line []byte, i int) int {
+	for j := i + 1; j < len(line); j++ {
+		switch line[j] {
+		case '\\':
+			j++
+		case '"':
+			return j
+		}
+	}
+	return -1
+}
+
+// memberAddress parses the value of a member, after its key.
+func memberAddress(rest []byte) (uint64, bool) {
+	rest = bytes.TrimLeft(rest, " \t")
+	if len(rest) == 0 || rest[0] != ':' {
+		return 0, false
+	}
+	rest = bytes.TrimLeft(rest[1:], " \t")
+	if len(rest) == 0 || rest[0] != '"' {
+		return 0, false
+	}
+	end := bytes.IndexByte(rest[1:], '"')
+	if end < 0 {
+		return 0, false
+	}
+	addr, err := ParseAddress(string(rest[1 : end+1]))
+	return addr, err == nil
+}
+
+// ObjectReader looks up single objects of a dump by address, decoding only
+// the line of the object with the fatherhood decoder.  It's safe for
+// concurrent use.
+type ObjectReader struct {
+	r io.ReaderAt
+
+	idxOnce sync.Once
+	idx     OffsetIndex
+	idxErr  error
+}
+
+// NewObjectReader reads the dump in r, finding lines with idx.  If idx is
+// nil, an index is built with BuildOffsetIndex on the first lookup.
+func NewObjectReader(r io.ReaderAt, idx OffsetIndex) *ObjectReader {
+	return &ObjectReader{r: r, idx: idx}
+}
+
+// Lookup decodes the object at addr.  The error is ErrNotFound if there's no
+// such object in the dump.
+func (o *ObjectReader) Lookup(addr uint64) (*RubyObject, error) {
+	o.idxOnce.Do(func() {
+		if o.idx == nil {
+			o.idx, o.idxErr = BuildOffsetIndex(io.NewSectionReader(o.r, 0, math.MaxInt64))
+		}
+	})
+	if o.idxErr != nil {
+		return nil, o.idxErr
+	}
+
+	offset, length, ok := o.idx.Offset(addr)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	line := make([]byte, length)
+	n, err := o.r.ReadAt(line, offset)
+	if n < len(line) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("reading object at offset %d: %v", offset, err)
+	}
+
+	rObj := new(RubyObject)
+	if err := newLineDecoder().decode(line, rObj); err != nil {
+		return nil, fmt.Errorf("decoding object at offset %d: %v", offset, err)
+	}
+	if rObj.Address != addr {
+		return nil, fmt.Errorf("index is stale: found %s at offset %d instead of %s",
+			FormatAddress(rObj.Address), offset, FormatAddress(addr))
+	}
+	return rObj, nil
+}
@@ -0,0 +1,196 @@
+package rubyobj_test
+
+import (
+	"bytes"
+	"github.com/aybabtme/rubyobj"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sameObject compares objects like reflect.DeepEqual, except that NaN values
+// are equal.
+func sameObject(a, b rubyobj.RubyObject) bool {
+	af, aok := a.Value.(float64)
+	bf, bok := b.Value.(float64)
+	if aok && bok && math.IsNaN(af) && math.IsNaN(bf) {
+		a.Value, b.Value = nil, nil
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestObjectReaderSmallDump(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/small.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := decodeFile(t, "testdata/small.json")
+
+	idx, err := rubyobj.BuildOffsetIndex(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each object is found at the start of its own line.
+	var offset int64
+	lines := strings.SplitAfter(string(data), "\n")
+	for i, line := range lines {
+		if i == len(objs) {
+			break
+		}
+		addr := objs[i].Address
+		if objs[i].Type == rubyobj.Root {
+			offset += int64(len(line))
+			continue
+		}
+		off, length, ok := idx.Offset(addr)
+		if !ok || off != offset || length != int64(len(line)) {
+			t.Fatalf("want %s at %d for %d bytes, got %d for %d bytes (%v)",
+				rubyobj.FormatAddress(addr), offset, len(line), off, length, ok)
+		}
+		offset += int64(len(line))
+	}
+
+	// Both with an index given and one built on the first lookup.
+	for _, r := range []*rubyobj.ObjectReader{
+		rubyobj.NewObjectReader(bytes.NewReader(data), idx),
+		rubyobj.NewObjectReader(bytes.NewReader(data), nil),
+	} {
+		for i := range objs {
+			if objs[i].Type == rubyobj.Root {
+				continue
+			}
+			got, err := r.Lookup(objs[i].Address)
+			if err != nil {
+				t.Fatalf("%s: %v", rubyobj.FormatAddress(objs[i].Address), err)
+			}
+			if !sameObject(*got, objs[i]) {
+				t.Fatalf("want %+v, got %+v", objs[i], *got)
+			}
+		}
+		if _, err := r.Lookup(0x7fc96c000001); err != rubyobj.ErrNotFound {
+			t.Errorf("want ErrNotFound, got %v", err)
+		}
+	}
+}
+
+func TestObjectReaderOddLines(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	lines := []string{
+		`{"type":"ROOT", "root":"vm", "references":["0x7fc96c000001"]}` + "\n",
+		`{"address":"0x7fc96c000001", "type":"STRING", "value":"` + long + `"}` + "\n",
+		"\n",
+		// The key only counts with its quotes.
+		`{"value":"\"address\": \"0x7fc96c000009\"", "address" :  "0x7fc96c000002", "type":"STRING"}` + "\r\n",
+		// Nor as a value, or in a nested object.
+		`{"type":"SYMBOL", "value":"address", "address":"0x7fc96c000004"}` + "\n",
+		`{"type":"OBJECT", "x":{"address":"0x7fc96c00000a"}, "references":["address"], "address":"0x7fc96c000005"}` + "\n",
+		`{"address":"0x7fc96c000003", "type":"OBJECT"}`,
+	}
+	dump := strings.Join(lines, "")
+	idx, err := rubyobj.BuildOffsetIndex(strings.NewReader(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint64][2]int{
+		0x7fc96c000001: {len(lines[0]), len(lines[1])},
+		0x7fc96c000002: {len(lines[0]) + len(lines[1]) + 1, len(lines[3])},
+		0x7fc96c000004: {len(lines[0]) + len(lines[1]) + 1 + len(lines[3]), len(lines[4])},
+		0x7fc96c000005: {len(dump) - len(lines[6]) - len(lines[5]), len(lines[5])},
+		0x7fc96c000003: {len(dump) - len(lines[6]), len(lines[6])},
+	}
+	for addr, w := range want {
+		off, length, ok := idx.Offset(addr)
+		if !ok || off != int64(w[0]) || length != int64(w[1]) {
+			t.Errorf("want %s at %d for %d bytes, got %d for %d bytes (%v)",
+				rubyobj.FormatAddress(addr), w[0], w[1], off, length, ok)
+		}
+	}
+	for _, addr := range []uint64{0x7fc96c000009, 0x7fc96c00000a} {
+		if _, _, ok := idx.Offset(addr); ok {
+			t.Errorf("found %s in a value", rubyobj.FormatAddress(addr))
+		}
+	}
+
+	r := rubyobj.NewObjectReader(strings.NewReader(dump), idx)
+	if rObj, err := r.Lookup(0x7fc96c000001); err != nil || rObj.Value != long {
+		t.Errorf("want the long string, got %v", err)
+	}
+	if rObj, err := r.Lookup(0x7fc96c000003); err != nil || rObj.Type != rubyobj.Object {
+		t.Errorf("want the last object, got %+v (%v)", rObj, err)
+	}
+}
+
+// offsets is an index given by hand.
+type offsets map[uint64][2]int64
+
+func (o offsets) Offset(addr uint64) (int64, int64, bool) {
+	off, ok := o[addr]
+	return off[0], off[1], ok
+}
+
+func TestObjectReaderBadIndex(t *testing.T) {
+	const dump = `{"address":"0x7fc96c000001", "type":"OBJECT"}
+{"address":"0x7fc96c000002", "type":"OBJECT"}
+`
+	r := rubyobj.NewObjectReader(strings.NewReader(dump), offsets{
+		// the line of the other object
+		0x7fc96c000001: {46, 46},
+		// past the end
+		0x7fc96c000002: {46, 100},
+		// in the middle of a line
+		0x7fc96c000003: {10, 20},
+	})
+	tests := []struct {
+		addr uint64
+		want string
+	}{
+		{0x7fc96c000001, "index is stale: found 0x7fc96c000002 at offset 46 instead of 0x7fc96c000001"},
+		{0x7fc96c000002, "reading object at offset 46: unexpected EOF"},
+		{0x7fc96c000003, "decoding object at offset 10"},
+	}
+	for _, tt := range tests {
+		_, err := r.Lookup(tt.addr)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: want an error about %q, got %v", rubyobj.FormatAddress(tt.addr), tt.want, err)
+		}
+	}
+}
+
+func TestObjectReaderWithIndex(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/tiny.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "tiny.json")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := rubyobj.OpenIndex(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r := rubyobj.NewObjectReader(f, idx)
+	for _, rObj := range decodeFile(t, "testdata/tiny.json") {
+		if rObj.Type == rubyobj.Root {
+			continue
+		}
+		got, err := r.Lookup(rObj.Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameObject(*got, rObj) {
+			t.Fatalf("want %+v, got %+v", rObj, *got)
+		}
+	}
+}
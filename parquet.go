@@ -0,0 +1,99 @@
+package rubyobj
+
+import (
+	"fmt"
+	"github.com/xitongsys/parquet-go/writer"
+	"io"
+)
+
+// parquetDictionary are the columns of the objects table with few distinct
+// values, which are dictionary encoded.
+var parquetDictionary = map[string]bool{
+	"type":      true,
+	"root":      true,
+	"class":     true,
+	"node_type": true,
+	"file":      true,
+	"method":    true,
+	"encoding":  true,
+	"struct":    true,
+}
+
+// parquetSchema is the schema of the objects table, with the columns of
+// objectColumns like TableWriter.
+func parquetSchema() []string {
+	md := make([]string, len(objectColumns))
+	for i, col := range objectColumns {
+		switch col.typ {
+		case "long":
+			md[i] = fmt.Sprintf("name=%s, type=INT64", col.name)
+		case "boolean":
+			md[i] = fmt.Sprintf("name=%s, type=BOOLEAN", col.name)
+		default:
+			md[i] = fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8", col.name)
+			if parquetDictionary[col.name] {
+				md[i] += ", encoding=PLAIN_DICTIONARY"
+			}
+		}
+	}
+	return md
+}
+
+// parquetEdge is a row of the edges table.
+type parquetEdge struct {
+	From    string `parquet:"name=from, type=BYTE_ARRAY, convertedtype=UTF8"`
+	To      string `parquet:"name=to, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Ordinal int64  `parquet:"name=ordinal, type=INT64"`
+}
+
+// ParquetWriter writes RubyObjects as two Apache Parquet files, holding the
+// same objects and edges tables as TableWriter.
+type ParquetWriter struct {
+	objects *writer.CSVWriter
+	edges   *writer.ParquetWriter
+}
+
+// NewParquetWriter creates a writer of the objects and edges tables.  Close
+// must be called to complete the files.
+func NewParquetWriter(objects, edges io.Writer) (*ParquetWriter, error) {
+	ow, err := writer.NewCSVWriterFromWriter(parquetSchema(), objects, 1)
+	if err != nil {
+		return nil, err
+	}
+	ew, err := writer.NewParquetWriterFromWriter(edges, new(parquetEdge), 1)
+	if err != nil {
+		return nil, err
+	}
+	return &ParquetWriter{objects: ow, edges: ew}, nil
+}
+
+// Write adds a row for rObj to the objects table, and a row for each of its
+// references to the edges table.
+func (p *ParquetWriter) Write(rObj *RubyObject) error {
+	// The writer keeps the rows until it flushes them, so each has its own.
+	row := make([]interface{}, len(objectColumns))
+	for i, col := range objectColumns {
+		row[i] = typedValue(col, rObj)
+	}
+	if err := p.objects.Write(row); err != nil {
+		return err
+	}
+
+	from := formatOptAddress(rObj.Address)
+	for i, to := range rObj.References {
+		err := p.edges.Write(parquetEdge{From: from, To: FormatAddress(to), Ordinal: int64(i)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes the remaining rows and writes the footers of both files.  It
+// doesn't close the underlying writers.
+func (p *ParquetWriter) Close() error {
+	if err := p.objects.WriteStop(); err != nil {
+		return err
+	}
+	return p.edges.WriteStop()
+}
@@ -0,0 +1,142 @@
+package rubyobj
+
+import (
+	"bytes"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/reader"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParquetSchema(t *testing.T) {
+	md := parquetSchema()
+	if len(md) != len(objectColumns) {
+		t.Fatalf("want %d columns, got %d", len(objectColumns), len(md))
+	}
+	types := map[string]string{
+		"string":  "type=BYTE_ARRAY, convertedtype=UTF8",
+		"long":    "type=INT64",
+		"boolean": "type=BOOLEAN",
+	}
+	dict := 0
+	for i, col := range objectColumns {
+		if !strings.HasPrefix(md[i], "name="+col.name+", "+types[col.typ]) {
+			t.Errorf("want column %s of type %s, got %q", col.name, col.typ, md[i])
+		}
+		if strings.HasSuffix(md[i], "encoding=PLAIN_DICTIONARY") {
+			dict++
+		}
+	}
+	if dict != len(parquetDictionary) {
+		t.Errorf("want %d dictionary encoded columns, got %d", len(parquetDictionary), dict)
+	}
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	var objs []RubyObject
+	dec := NewDecoder(bytes.NewReader(readDump(t, "tiny.json")))
+	for {
+		var rObj RubyObject
+		err := dec.Decode(&rObj)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		objs = append(objs, rObj)
+	}
+
+	dir := t.TempDir()
+	objectsPath, edgesPath := filepath.Join(dir, "objects.parquet"), filepath.Join(dir, "edges.parquet")
+	of, err := os.Create(objectsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer of.Close()
+	ef, err := os.Create(edgesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	w, err := NewParquetWriter(of, ef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range objs {
+		if err := w.Write(&objs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opf, err := local.NewLocalFileReader(objectsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opf.Close()
+	pr, err := reader.NewParquetColumnReader(opf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	n := pr.GetNumRows()
+	if n != int64(len(objs)) {
+		t.Fatalf("want %d objects, got %d", len(objs), n)
+	}
+	column := func(name string) []interface{} {
+		values, _, _, err := pr.ReadColumnByPath(common.ReformPathStr("parquet_go_root."+name), n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(values)) != n {
+			t.Fatalf("want %d values of %s, got %d", n, name, len(values))
+		}
+		return values
+	}
+	addresses, types, memsizes, olds := column("address"), column("type"), column("memsize"), column("old")
+	for i, rObj := range objs {
+		want := []interface{}{formatOptAddress(rObj.Address), rObj.Type.Name(), int64(rObj.Memsize), rObj.GcOld()}
+		got := []interface{}{addresses[i], types[i], memsizes[i], olds[i]}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("object %d: want %v, got %v", i, want, got)
+		}
+	}
+
+	epf, err := local.NewLocalFileReader(edgesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer epf.Close()
+	er, err := reader.NewParquetReader(epf, new(parquetEdge), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer er.ReadStop()
+	var want []parquetEdge
+	for _, rObj := range objs {
+		for i, to := range rObj.References {
+			want = append(want, parquetEdge{From: formatOptAddress(rObj.Address), To: FormatAddress(to), Ordinal: int64(i)})
+		}
+	}
+	if len(want) == 0 {
+		t.Fatal("tiny.json should have references")
+	}
+	if n := er.GetNumRows(); n != int64(len(want)) {
+		t.Fatalf("want %d edges, got %d", len(want), n)
+	}
+	edges := make([]parquetEdge, len(want))
+	if err := er.Read(&edges); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(edges, want) {
+		t.Errorf("the edges differ from the references of the objects")
+	}
+}
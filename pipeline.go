@@ -0,0 +1,461 @@
+package rubyobj
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// Record is an object of a dump along with the line it was decoded from.
+// Encoding a record with Encoder.EncodeRecord writes back the members of the
+// line that RubyObject doesn't know about, so that filtering or transforming
+// a dump doesn't lose anything.
+type Record struct {
+	RubyObject
+
+	line int
+	raw  []byte
+	orig RubyObject
+	keep map[string]bool
+}
+
+func newRecord(line int, raw []byte, rObj RubyObject) Record {
+	orig := rObj
+	if rObj.References != nil {
+		orig.References = make([]uint64, len(rObj.References))
+		copy(orig.References, rObj.References)
+	}
+	return Record{RubyObject: rObj, line: line, raw: raw, orig: orig}
+}
+
+// modified tells if the record can't be written back as its original line.
+func (rec *Record) modified() bool {
+	if rec.raw == nil || rec.keep != nil {
+		return true
+	}
+	now, was := rec.RubyObject, rec.orig
+	if isNaN(now.Value) && isNaN(was.Value) {
+		now.Value, was.Value = nil, nil
+	}
+	return !reflect.DeepEqual(now, was)
+}
+
+func isNaN(v interface{}) bool {
+	f, ok := v.(float64)
+	return ok && math.IsNaN(f)
+}
+
+// marshal merges the members of the object into those of its original line.
+// Members that didn't change are written as they were.
+func (rec *Record) marshal() ([]byte, error) {
+	var raw []member
+	if rec.raw != nil {
+		var err error
+		if raw, err = objectMembers(rec.raw); err != nil {
+			return nil, err
+		}
+	}
+	was, err := schemaMembers(&rec.orig)
+	if err != nil {
+		return nil, err
+	}
+	if rec.raw == nil {
+		was = nil
+	}
+	now, err := schemaMembers(&rec.RubyObject)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := mergeMembers(raw, was, now)
+	if err != nil {
+		return nil, err
+	}
+	if rec.keep != nil {
+		kept := merged[:0]
+		for _, m := range merged {
+			if rec.keep[m.key] {
+				kept = append(kept, m)
+			}
+		}
+		merged = kept
+	}
+	return marshalMembers(merged), nil
+}
+
+// member is a member of a JSON object, in the order it appeared.
+type member struct {
+	key   string
+	value json.RawMessage
+}
+
+func objectMembers(data []byte) ([]member, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, fmt.Errorf("expected an object, got %v", t)
+	}
+	var members []member
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		m := member{key: t.(string)}
+		if err := dec.Decode(&m.value); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func marshalMembers(members []member) []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteByte('{')
+	for i, m := range members {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(m.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(m.value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+var (
+	zeroAddress = []byte(`"0x000000000000"`)
+	emptyObject = []byte(`{}`)
+)
+
+// schemaMembers are the members an Encoder writes for rObj, without the zero
+// addresses and empty flags that dumps don't have.
+func schemaMembers(rObj *RubyObject) ([]member, error) {
+	data, err := json.Marshal(rObj.saveSchema())
+	if err != nil {
+		return nil, err
+	}
+	members, err := objectMembers(data)
+	if err != nil {
+		return nil, err
+	}
+	kept := members[:0]
+	for _, m := range members {
+		switch m.key {
+		case "address", "class", "default":
+			if bytes.Equal(m.value, zeroAddress) {
+				continue
+			}
+		case "flags":
+			if bytes.Equal(m.value, emptyObject) {
+				continue
+			}
+		}
+		kept = append(kept, m)
+	}
+	return kept, nil
+}
+
+// mergeMembers applies the changes from was to now onto raw.  Members of raw
+// that are in neither were unknown to the schema and are kept as is.
+// Objects, like flags, are merged the same way.
+func mergeMembers(raw, was, now []member) ([]member, error) {
+	index := func(members []member) map[string]json.RawMessage {
+		m := make(map[string]json.RawMessage, len(members))
+		for _, mem := range members {
+			m[mem.key] = mem.value
+		}
+		return m
+	}
+	wasM, nowM := index(was), index(now)
+	seen := make(map[string]bool, len(raw))
+
+	var merged []member
+	for _, m := range raw {
+		seen[m.key] = true
+		w, inWas := wasM[m.key]
+		n, inNow := nowM[m.key]
+		switch {
+		case !inWas && !inNow:
+			merged = append(merged, m)
+		case inWas && bytes.Equal(w, n):
+			merged = append(merged, m)
+		case inWas && len(m.value) != 0 && m.value[0] == '{':
+			if !inNow {
+				n = emptyObject
+			}
+			value, err := mergeObjects(m.value, w, n)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(value, emptyObject) {
+				merged = append(merged, member{m.key, value})
+			}
+		case !inNow:
+			// cleared
+		default:
+			merged = append(merged, member{m.key, n})
+		}
+	}
+	for _, m := range now {
+		if !seen[m.key] {
+			merged = append(merged, m)
+		}
+	}
+	return merged, nil
+}
+
+func mergeObjects(raw, was, now json.RawMessage) (json.RawMessage, error) {
+	var members [3][]member
+	for i, data := range []json.RawMessage{raw, was, now} {
+		var err error
+		if members[i], err = objectMembers(data); err != nil {
+			return nil, err
+		}
+	}
+	merged, err := mergeMembers(members[0], members[1], members[2])
+	if err != nil {
+		return nil, err
+	}
+	return marshalMembers(merged), nil
+}
+
+// OrderedParallelDecode is like ParallelDecode, but the objects come out in
+// the order of the lines of r.  It's a bit slower, as objects decoded early
+// wait for the lines before them.
+func OrderedParallelDecode(r io.Reader, para uint) (<-chan RubyObject, <-chan error) {
+	bufLen := para << 2
+	decodedC := make(chan RubyObject, bufLen)
+	errc := make(chan error, bufLen)
+
+	go func() {
+		defer close(decodedC)
+		defer close(errc)
+
+		err := decodeRecords(r, para, func(rec *Record) error {
+			decodedC <- rec.RubyObject
+			return nil
+		}, func(err error) {
+			errc <- err
+		})
+		if err != nil {
+			errc <- err
+		}
+	}()
+
+	return decodedC, errc
+}
+
+// lineError is the error decoding a line of a dump, lines counting from 1.
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e *lineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.line, e.err)
+}
+
+// decodeRecords decodes the lines of r with para goroutines, calling fn with
+// the records in the order of the lines, and errFn with the *lineError of the
+// lines that can't be decoded.  It stops at the first error of fn, returning
+// it, or returns the error reading r, if any.
+func decodeRecords(r io.Reader, para uint, fn func(*Record) error, errFn func(error)) error {
+	type line struct {
+		seq, num int
+		data     []byte
+	}
+	type result struct {
+		seq int
+		rec Record
+		err error
+	}
+
+	// Tokens bound how far ahead of the record being waited for the decoders
+	// can go, and so how many records wait to be put back in order.
+	window := int(para<<4) + 1
+	tokens := make(chan struct{}, window)
+	stop := make(chan struct{})
+
+	lineC := make(chan line, para<<2)
+	resultC := make(chan result, para<<2)
+	readErr := make(chan error, 1)
+
+	go func() {
+		defer close(lineC)
+		br := bufio.NewReader(r)
+		for seq, num := 0, 1; ; num++ {
+			// Blank lines don't wait for a token, so stop is checked before
+			// each read, not only when sending.
+			select {
+			case <-stop:
+				readErr <- nil
+				return
+			default:
+			}
+			data, err := br.ReadBytes('\n')
+			if len(bytes.TrimSpace(data)) != 0 {
+				select {
+				case tokens <- struct{}{}:
+				case <-stop:
+					readErr <- nil
+					return
+				}
+				lineC <- line{seq, num, data}
+				seq++
+			}
+			if err == io.EOF {
+				readErr <- nil
+				return
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := uint(0); i < para; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dec := newLineDecoder()
+			for l := range lineC {
+				res := result{seq: l.seq}
+				var rObj RubyObject
+				if err := dec.decode(l.data, &rObj); err != nil {
+					res.err = &lineError{l.num, err}
+				} else {
+					res.rec = newRecord(l.num, l.data, rObj)
+				}
+				resultC <- res
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(resultC)
+	}()
+
+	var fnErr error
+	pending := make(map[int]result, window)
+	next := 0
+	for res := range resultC {
+		pending[res.seq] = res
+		for {
+			res, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			<-tokens
+
+			switch {
+			case fnErr != nil:
+			case res.err != nil:
+				errFn(res.err)
+			default:
+				if fnErr = fn(&res.rec); fnErr != nil {
+					close(stop)
+				}
+			}
+		}
+	}
+	if fnErr != nil {
+		return fnErr
+	}
+	return <-readErr
+}
+
+// Pipeline filters and transforms the objects of a dump, one after the
+// other, writing what's left as a dump.  The output is in the order of the
+// input, and keeps the members of objects that RubyObject doesn't know about.
+type Pipeline struct {
+	steps []func(*Record) bool
+}
+
+// NewPipeline returns a pipeline that copies a dump as is.
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// Where drops the objects for which keep is false.
+func (p *Pipeline) Where(keep func(*RubyObject) bool) *Pipeline {
+	p.steps = append(p.steps, func(rec *Record) bool {
+		return keep(&rec.RubyObject)
+	})
+	return p
+}
+
+// Transform modifies the objects with fn.
+func (p *Pipeline) Transform(fn func(*RubyObject)) *Pipeline {
+	p.steps = append(p.steps, func(rec *Record) bool {
+		fn(&rec.RubyObject)
+		return true
+	})
+	return p
+}
+
+// Project writes only the given members of the objects, like "memsize" or
+// "references".  The type, address and root members are always written, so
+// the output is still a dump.
+func (p *Pipeline) Project(members ...string) *Pipeline {
+	keep := map[string]bool{"type": true, "address": true, "root": true}
+	for _, m := range members {
+		keep[m] = true
+	}
+	p.steps = append(p.steps, func(rec *Record) bool {
+		rec.keep = keep
+		return true
+	})
+	return p
+}
+
+// PipelineStats counts what a pipeline did.
+type PipelineStats struct {
+	Read    uint64
+	Written uint64
+}
+
+// Run decodes the dump in r with para goroutines, and writes the objects
+// that make it through the steps of the pipeline to w.  Lines that can't be
+// decoded are dropped; like LoadHeap, the error then says how many there
+// were, after all the others were written.
+func (p *Pipeline) Run(r io.Reader, w io.Writer, para uint) (PipelineStats, error) {
+	var stats PipelineStats
+	var errs decodeErrors
+
+	enc := NewEncoder(w)
+	err := decodeRecords(r, para, func(rec *Record) error {
+		stats.Read++
+		for _, step := range p.steps {
+			if !step(rec) {
+				return nil
+			}
+		}
+		stats.Written++
+		return enc.EncodeRecord(rec)
+	}, func(err error) {
+		if errs.first == nil {
+			errs.first = err
+		}
+		errs.count++
+	})
+	if err != nil {
+		return stats, err
+	}
+	if errs.count != 0 {
+		return stats, &errs
+	}
+	return stats, nil
+}
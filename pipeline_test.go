@@ -0,0 +1,270 @@
+package rubyobj
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func readDump(t *testing.T, name string) []byte {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if filepath.Ext(name) != ".gz" {
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPipelineIdentity(t *testing.T) {
+	for _, name := range []string{"tiny.json", "small.json", "medium.json.gz"} {
+		data := readDump(t, name)
+		out := bytes.NewBuffer(nil)
+		p := NewPipeline().
+			Where(func(*RubyObject) bool { return true }).
+			Transform(func(*RubyObject) {})
+		stats, err := p.Run(bytes.NewReader(data), out, 4)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if stats.Read != stats.Written || stats.Read == 0 {
+			t.Errorf("%s: read %d objects, wrote %d", name, stats.Read, stats.Written)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("%s: the output differs from the input", name)
+		}
+	}
+}
+
+func TestPipelineMerge(t *testing.T) {
+	const line = `{"address":"0x7fc96c000001","type":"STRING","extra":[1,{"a":2}],"value":"abc","memsize":40,"flags":{"wb_protected":true,"mystery":1,"old":true}}`
+	tests := []struct {
+		name string
+		fn   func(*RubyObject)
+		want string
+	}{
+		{
+			"unchanged",
+			func(*RubyObject) {},
+			line,
+		},
+		{
+			"changed member",
+			func(rObj *RubyObject) { rObj.Memsize = 80 },
+			`{"address":"0x7fc96c000001","type":"STRING","extra":[1,{"a":2}],"value":"abc","memsize":80,"flags":{"wb_protected":true,"mystery":1,"old":true}}`,
+		},
+		{
+			"cleared member",
+			func(rObj *RubyObject) { rObj.Memsize = 0 },
+			`{"address":"0x7fc96c000001","type":"STRING","extra":[1,{"a":2}],"value":"abc","flags":{"wb_protected":true,"mystery":1,"old":true}}`,
+		},
+		{
+			"new member",
+			func(rObj *RubyObject) { rObj.File = "app.rb" },
+			`{"address":"0x7fc96c000001","type":"STRING","extra":[1,{"a":2}],"value":"abc","memsize":40,"flags":{"wb_protected":true,"mystery":1,"old":true},"file":"app.rb"}`,
+		},
+		{
+			"cleared flag",
+			func(rObj *RubyObject) { rObj.flags &^= gcOld },
+			`{"address":"0x7fc96c000001","type":"STRING","extra":[1,{"a":2}],"value":"abc","memsize":40,"flags":{"wb_protected":true,"mystery":1}}`,
+		},
+		{
+			"new flag",
+			func(rObj *RubyObject) { rObj.flags |= gcMarked },
+			`{"address":"0x7fc96c000001","type":"STRING","extra":[1,{"a":2}],"value":"abc","memsize":40,"flags":{"wb_protected":true,"mystery":1,"old":true,"marked":true}}`,
+		},
+		{
+			// The unknown flag keeps the flags around.
+			"cleared flags",
+			func(rObj *RubyObject) { rObj.flags &^= gcOld | gcWbProtected },
+			`{"address":"0x7fc96c000001","type":"STRING","extra":[1,{"a":2}],"value":"abc","memsize":40,"flags":{"mystery":1}}`,
+		},
+	}
+	for _, tt := range tests {
+		out := bytes.NewBuffer(nil)
+		if _, err := NewPipeline().Transform(tt.fn).Run(strings.NewReader(line+"\n"), out, 1); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := strings.TrimSuffix(out.String(), "\n"); got != tt.want {
+			t.Errorf("%s:\nwant %s\n got %s", tt.name, tt.want, got)
+		}
+	}
+
+	// Without unknown flags, clearing them all drops the member.
+	out := bytes.NewBuffer(nil)
+	_, err := NewPipeline().Transform(func(rObj *RubyObject) {
+		rObj.flags &^= gcOld
+	}).Run(strings.NewReader(`{"address":"0x7fc96c000001","type":"OBJECT","flags":{"old":true},"x":1}`+"\n"), out, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"address":"0x7fc96c000001","type":"OBJECT","x":1}` + "\n"; out.String() != want {
+		t.Errorf("want %s, got %s", want, out.String())
+	}
+}
+
+func TestPipelineProject(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+	_, err := NewPipeline().Project("memsize").Run(strings.NewReader(`{"type":"ROOT","root":"vm","references":["0x7fc96c000001"]}
+{"address":"0x7fc96c000001","type":"STRING","extra":1,"value":"abc","memsize":40}
+`), out, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"ROOT","root":"vm"}
+{"address":"0x7fc96c000001","type":"STRING","memsize":40}
+`
+	if out.String() != want {
+		t.Errorf("want\n%s got\n%s", want, out.String())
+	}
+}
+
+// stallingReader gives a line, waits for it to be rejected, and then gives
+// blank lines, counting them.
+type stallingReader struct {
+	line     []byte
+	rejected chan struct{}
+	blanks   int
+}
+
+func (r *stallingReader) Read(p []byte) (int, error) {
+	if r.line != nil {
+		n := copy(p, r.line)
+		r.line = nil
+		return n, nil
+	}
+	if r.blanks == 0 {
+		<-r.rejected
+		// Let the decoding stop.
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r.blanks == 1000 {
+		return 0, io.EOF
+	}
+	r.blanks++
+	return copy(p, "\n"), nil
+}
+
+func TestDecodeRecordsStopsReading(t *testing.T) {
+	r := &stallingReader{
+		line:     []byte(`{"address":"0x7fc96c000001","type":"OBJECT"}` + "\n"),
+		rejected: make(chan struct{}),
+	}
+	fail := errors.New("fail")
+	err := decodeRecords(r, 1, func(*Record) error {
+		close(r.rejected)
+		return fail
+	}, func(err error) {
+		t.Error(err)
+	})
+	if err != fail {
+		t.Fatalf("want the error of fn, got %v", err)
+	}
+	if r.blanks > 1 {
+		t.Errorf("read %d lines after the error", r.blanks)
+	}
+}
+
+func TestOrderedParallelDecode(t *testing.T) {
+	data := readDump(t, "medium.json.gz")
+	var want []RubyObject
+	dec := NewDecoder(bytes.NewReader(data))
+	for {
+		var rObj RubyObject
+		err := dec.Decode(&rObj)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, rObj)
+	}
+
+	// Lines that can't be decoded on lines 3 and 11, and a blank line on
+	// line 6 which isn't an error.
+	lines := strings.SplitAfter(string(data), "\n")
+	var in []string
+	in = append(in, lines[:2]...)
+	in = append(in, "nope\n")
+	in = append(in, lines[2:4]...)
+	in = append(in, "\n")
+	in = append(in, lines[4:8]...)
+	in = append(in, `{"address":`+"\n")
+	in = append(in, lines[8:]...)
+
+	objC, errC := OrderedParallelDecode(strings.NewReader(strings.Join(in, "")), 4)
+	var got []RubyObject
+	var errLines []int
+	for objC != nil || errC != nil {
+		select {
+		case rObj, ok := <-objC:
+			if !ok {
+				objC = nil
+				continue
+			}
+			got = append(got, rObj)
+		case err, ok := <-errC:
+			if !ok {
+				errC = nil
+				continue
+			}
+			lerr, isLine := err.(*lineError)
+			if !isLine {
+				t.Fatalf("want line errors, got %v", err)
+			}
+			errLines = append(errLines, lerr.line)
+		}
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("want %d objects, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if !sameObject(got[i], want[i]) {
+			t.Fatalf("object %d is out of order: want %+v, got %+v", i, want[i], got[i])
+		}
+	}
+	if len(errLines) != 2 || errLines[0] != 3 || errLines[1] != 11 {
+		t.Errorf("want errors on lines 3 and 11, got %v", errLines)
+	}
+}
+
+func TestOrderedParallelDecodeReadError(t *testing.T) {
+	fail := errors.New("fail")
+	r := io.MultiReader(strings.NewReader(`{"address":"0x7fc96c000001","type":"OBJECT"}`+"\n"), iotest.ErrReader(fail))
+	objC, errC := OrderedParallelDecode(r, 2)
+	var n int
+	for range objC {
+		n++
+	}
+	if err := <-errC; err != fail {
+		t.Errorf("want the error reading, got %v", err)
+	}
+	if n != 1 {
+		t.Errorf("want the object before the error, got %d objects", n)
+	}
+}
@@ -0,0 +1,163 @@
+package rubyobj
+
+import (
+	"github.com/google/pprof/profile"
+	"io"
+)
+
+// profileSite is the allocation site of objects, a location of the profile.
+type profileSite struct {
+	file   string
+	line   uint64
+	method string
+}
+
+// profileStack is a stack of allocation sites: an object's, then those of its
+// dominators.  Stacks are interned as a tree, so objects with the same chain
+// of sites share the same *profileStack.
+type profileStack struct {
+	loc    *profile.Location
+	parent *profileStack
+}
+
+type profileKey struct {
+	stack *profileStack
+	class string
+	typ   RubyType
+}
+
+// WriteProfile converts the heap to a pprof profile, readable with
+// `go tool pprof`.  Objects are sampled with three values: their count, their
+// memsize and their retained size.  Each sample is located at the allocation
+// site of its objects, called from the sites of their dominators, so that
+// the memsize under a frame of a flame graph is the memory retained by the
+// objects allocated there.  Samples are labelled with the class and type of
+// their objects.
+//
+// Objects sharing a stack, a class and a type are merged in a single sample,
+// and consecutive dominators allocated at the same site are a single frame.
+// Since objects can dominate each other, retained sizes add up to more than
+// the size of the heap.
+func WriteProfile(w io.Writer, h *Heap) error {
+	dom := NewDominatorTree(h)
+
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "objects", Unit: "count"},
+			{Type: "memsize", Unit: "bytes"},
+			{Type: "retained", Unit: "bytes"},
+		},
+		DefaultSampleType: "memsize",
+		PeriodType:        &profile.ValueType{Type: "space", Unit: "bytes"},
+		Period:            1,
+	}
+
+	functions := make(map[[2]string]*profile.Function)
+	locations := make(map[profileSite]*profile.Location)
+	samples := make(map[profileKey]*profile.Sample)
+
+	location := func(rObj *RubyObject) *profile.Location {
+		key := profileSite{file: rObj.File, line: rObj.Line, method: rObj.Method}
+		if loc, ok := locations[key]; ok {
+			return loc
+		}
+		name, file := key.method, key.file
+		if file == "" {
+			name = "(untraced)"
+		} else if name == "" {
+			name = "(unknown method)"
+		}
+		fn, ok := functions[[2]string{name, file}]
+		if !ok {
+			fn = &profile.Function{
+				ID:         uint64(len(p.Function) + 1),
+				Name:       name,
+				SystemName: name,
+				Filename:   file,
+			}
+			functions[[2]string{name, file}] = fn
+			p.Function = append(p.Function, fn)
+		}
+		loc := &profile.Location{
+			ID:   uint64(len(p.Location) + 1),
+			Line: []profile.Line{{Function: fn, Line: int64(key.line)}},
+		}
+		locations[key] = loc
+		p.Location = append(p.Location, loc)
+		return loc
+	}
+
+	interned := make(map[profileStack]*profileStack)
+	stacks := make(map[uint64]*profileStack, h.Len())
+	var chain []*RubyObject
+
+	// stack walks up the dominators of rObj until one whose stack is known,
+	// then pushes the sites of those below it.
+	stack := func(rObj *RubyObject) *profileStack {
+		var parent *profileStack
+		chain = chain[:0]
+		for {
+			if st, ok := stacks[rObj.Address]; ok {
+				parent = st
+				break
+			}
+			chain = append(chain, rObj)
+			idom, ok := dom.ImmediateDominator(rObj.Address)
+			if !ok {
+				break
+			}
+			if rObj, ok = h.Lookup(idom); !ok {
+				break
+			}
+		}
+		for i := len(chain) - 1; i >= 0; i-- {
+			loc := location(chain[i])
+			if parent == nil || parent.loc != loc {
+				key := profileStack{loc: loc, parent: parent}
+				st, ok := interned[key]
+				if !ok {
+					st = &key
+					interned[key] = st
+				}
+				parent = st
+			}
+			stacks[chain[i].Address] = parent
+		}
+		return parent
+	}
+
+	objs := h.Objects()
+	for i := range objs {
+		rObj := &objs[i]
+		key := profileKey{
+			stack: stack(rObj),
+			class: h.ClassName(rObj),
+			typ:   rObj.Type,
+		}
+		s, ok := samples[key]
+		if !ok {
+			var locs []*profile.Location
+			for st := key.stack; st != nil; st = st.parent {
+				locs = append(locs, st.loc)
+			}
+			s = &profile.Sample{
+				Location: locs,
+				Value:    make([]int64, 3),
+				Label: map[string][]string{
+					"class": {key.class},
+					"type":  {key.typ.Name()},
+				},
+			}
+			samples[key] = s
+			p.Sample = append(p.Sample, s)
+		}
+		s.Value[0]++
+		s.Value[1] += int64(rObj.Memsize)
+		s.Value[2] += int64(dom.RetainedSize(rObj.Address))
+	}
+
+	if err := p.CheckValid(); err != nil {
+		return err
+	}
+	return p.Write(w)
+}
@@ -0,0 +1,93 @@
+package rubyobj_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aybabtme/rubyobj"
+	"github.com/google/pprof/profile"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWriteProfile(t *testing.T) {
+	// a dominates everything reachable: b and d, c under b, e under d, and
+	// f which both b and d reference.  d and e were allocated at the same
+	// sites as a and b.  ff isn't reachable.
+	h := loadHeap(t, `{"type":"ROOT", "root":"vm", "references":["0x7fc96c0000a0"]}
+{"address":"0x7fc96c000100", "type":"CLASS", "name":"Foo"}
+{"address":"0x7fc96c0000a0", "type":"OBJECT", "class":"0x7fc96c000100", "file":"a.rb", "line":1, "method":"make_a", "memsize":10, "references":["0x7fc96c0000b0", "0x7fc96c0000d0"]}
+{"address":"0x7fc96c0000b0", "type":"OBJECT", "class":"0x7fc96c000100", "file":"b.rb", "line":2, "method":"make_b", "memsize":20, "references":["0x7fc96c0000c0", "0x7fc96c0000f0"]}
+{"address":"0x7fc96c0000c0", "type":"STRING", "file":"c.rb", "line":3, "memsize":40}
+{"address":"0x7fc96c0000d0", "type":"OBJECT", "class":"0x7fc96c000100", "file":"a.rb", "line":1, "method":"make_a", "memsize":80, "references":["0x7fc96c0000e0", "0x7fc96c0000f0"]}
+{"address":"0x7fc96c0000e0", "type":"OBJECT", "class":"0x7fc96c000100", "file":"b.rb", "line":2, "method":"make_b", "memsize":160}
+{"address":"0x7fc96c0000f0", "type":"OBJECT", "class":"0x7fc96c000100", "file":"c.rb", "line":3, "memsize":320}
+{"address":"0x7fc96c0000ff", "type":"OBJECT", "memsize":640}
+`)
+	buf := bytes.NewBuffer(nil)
+	if err := rubyobj.WriteProfile(buf, h); err != nil {
+		t.Fatal(err)
+	}
+	// Parse gunzips the profile.proto.
+	p, err := profile.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var types []string
+	for _, st := range p.SampleType {
+		types = append(types, st.Type+"/"+st.Unit)
+	}
+	if want := []string{"objects/count", "memsize/bytes", "retained/bytes"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("want sample types %v, got %v", want, types)
+	}
+	if p.DefaultSampleType != "memsize" {
+		t.Errorf("want memsize by default, got %q", p.DefaultSampleType)
+	}
+
+	// Stacks go from the site of the objects up the sites of their
+	// dominators, a frame per site.
+	var got []string
+	for _, s := range p.Sample {
+		var frames []string
+		for _, loc := range s.Location {
+			if len(loc.Line) != 1 {
+				t.Fatalf("want a line per location, got %+v", loc.Line)
+			}
+			l := loc.Line[0]
+			frames = append(frames, fmt.Sprintf("%s:%d %s", l.Function.Filename, l.Line, l.Function.Name))
+		}
+		got = append(got, fmt.Sprintf("%s %s: %s: %v", s.Label["type"][0], s.Label["class"][0], strings.Join(frames, " < "), s.Value))
+	}
+	sort.Strings(got)
+	want := []string{
+		"CLASS 0x000000000000: :0 (untraced): [1 0 0]",
+		"OBJECT 0x000000000000: :0 (untraced): [1 640 640]",
+		"OBJECT Foo: a.rb:1 make_a: [2 90 870]",
+		"OBJECT Foo: b.rb:2 make_b < a.rb:1 make_a: [2 180 220]",
+		"OBJECT Foo: c.rb:3 (unknown method) < a.rb:1 make_a: [1 320 320]",
+		"STRING 0x000000000000: c.rb:3 (unknown method) < b.rb:2 make_b < a.rb:1 make_a: [1 40 40]",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want samples\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	// The values are those of the objects of each sample.
+	dom := rubyobj.NewDominatorTree(h)
+	var count, memsize, retained int64
+	for _, rObj := range h.Objects() {
+		count++
+		memsize += int64(rObj.Memsize)
+		retained += int64(dom.RetainedSize(rObj.Address))
+	}
+	var sums [3]int64
+	for _, s := range p.Sample {
+		for i := range sums {
+			sums[i] += s.Value[i]
+		}
+	}
+	if sums != [3]int64{count, memsize, retained} {
+		t.Errorf("want %d objects of %d bytes retaining %d, got %v", count, memsize, retained, sums)
+	}
+}
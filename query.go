@@ -0,0 +1,376 @@
+package rubyobj
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Query is a compiled graph query, a pipeline of stages separated by |.  Each
+// stage transforms a set of objects, starting from all the objects of the
+// heap:
+//
+//	where class_name == "Foo" | out 3 | where type == STRING
+//	held global_tbl | where class_name == "X"
+//
+// The stages are:
+//
+//	all:         every object of the heap
+//	roots [cat]: objects referenced by ROOT records, of category cat if given
+//	held cat:    objects reachable from the ROOT records of category cat, and
+//	             from no other ROOT record
+//	where expr:  objects of the set matching the filter expr, see Filter
+//	out [n|*]:   objects referenced by the set, up to n references away, 1
+//	             by default, any number with *
+//	in [n|*]:    objects referencing the set, like out
+//	reachable:   same as out *
+//	dominated:   objects dominated by an object of the set, see DominatorTree
+//	retained:    objects that would be freed if the whole set was freed
+type Query struct {
+	expr   string
+	stages []queryStage
+}
+
+type queryStage func(e *QueryEngine, set []bool) []bool
+
+// CompileQuery parses expr.
+func CompileQuery(expr string) (*Query, error) {
+	q := &Query{expr: expr}
+	lex := filterLexer{src: expr}
+	for {
+		name, err := lex.next()
+		if err != nil {
+			return nil, fmt.Errorf("query %q: %v", expr, err)
+		}
+		if name.kind != tokIdent {
+			return nil, fmt.Errorf("query %q: expected a stage at offset %d", expr, name.pos)
+		}
+
+		// The arguments of the stage run up to the next | or the end.
+		var args []token
+		argStart, argEnd := lex.pos, len(expr)
+		for {
+			tok, err := lex.next()
+			if err != nil {
+				return nil, fmt.Errorf("query %q: %v", expr, err)
+			}
+			if tok.kind == tokEOF || (tok.kind == tokOp && tok.text == "|") {
+				argEnd = tok.pos
+				break
+			}
+			args = append(args, tok)
+		}
+
+		stage, err := compileStage(name, args, expr[argStart:argEnd])
+		if err != nil {
+			return nil, fmt.Errorf("query %q: %v", expr, err)
+		}
+		q.stages = append(q.stages, stage)
+
+		if argEnd == len(expr) {
+			return q, nil
+		}
+	}
+}
+
+func (q *Query) String() string { return q.expr }
+
+func compileStage(name token, args []token, argSrc string) (queryStage, error) {
+	noArgs := func() error {
+		if len(args) != 0 {
+			return fmt.Errorf("%s takes no argument, got %q at offset %d", name.text, args[0].text, args[0].pos)
+		}
+		return nil
+	}
+
+	switch name.text {
+	case "all":
+		return allStage, noArgs()
+
+	case "roots", "held":
+		var category string
+		switch {
+		case len(args) == 1 && (args[0].kind == tokIdent || args[0].kind == tokString):
+			category = args[0].text
+		case len(args) == 0 && name.text == "roots":
+		case len(args) == 0:
+			return nil, fmt.Errorf("%s needs a root category at offset %d", name.text, name.pos)
+		default:
+			return nil, fmt.Errorf("%s takes a root category, got %q at offset %d", name.text, args[0].text, args[0].pos)
+		}
+		if name.text == "roots" {
+			return rootsStage(category), nil
+		}
+		return heldStage(category), nil
+
+	case "where":
+		filter, err := CompileFilter(strings.TrimSpace(argSrc))
+		if err != nil {
+			return nil, err
+		}
+		return whereStage(filter), nil
+
+	case "out", "in", "reachable":
+		depth := 1
+		switch {
+		case name.text == "reachable":
+			depth = -1
+			if err := noArgs(); err != nil {
+				return nil, err
+			}
+		case len(args) == 0:
+		case len(args) == 1 && args[0].kind == tokOp && args[0].text == "*":
+			depth = -1
+		case len(args) == 1 && args[0].kind == tokNumber:
+			n, err := strconv.Atoi(args[0].text)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("bad depth %q at offset %d", args[0].text, args[0].pos)
+			}
+			depth = n
+		default:
+			return nil, fmt.Errorf("%s takes a depth or *, got %q at offset %d", name.text, args[0].text, args[0].pos)
+		}
+		if name.text == "in" {
+			return walkStage((*QueryEngine).referrers, depth), nil
+		}
+		return walkStage((*QueryEngine).references, depth), nil
+
+	case "dominated":
+		return dominatedStage, noArgs()
+
+	case "retained":
+		return retainedStage, noArgs()
+	}
+	return nil, fmt.Errorf("unknown stage %q at offset %d", name.text, name.pos)
+}
+
+// QueryEngine runs queries against a heap.  What's expensive to compute, like
+// the dominator tree, is only done once for all the queries.
+type QueryEngine struct {
+	h *Heap
+
+	domOnce sync.Once
+	dom     *DominatorTree
+}
+
+// NewQueryEngine creates an engine querying h.
+func NewQueryEngine(h *Heap) *QueryEngine {
+	return &QueryEngine{h: h}
+}
+
+// Query compiles and runs expr.
+func (e *QueryEngine) Query(expr string) ([]uint64, error) {
+	q, err := CompileQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	return e.Run(q), nil
+}
+
+// Run returns the addresses of the objects selected by q, in heap order.
+func (e *QueryEngine) Run(q *Query) []uint64 {
+	set := allStage(e, nil)
+	for _, stage := range q.stages {
+		set = stage(e, set)
+	}
+	var addrs []uint64
+	for i, in := range set {
+		if in {
+			addrs = append(addrs, e.h.objects[i].Address)
+		}
+	}
+	return addrs
+}
+
+// DominatorTree is the dominator tree of the heap, computed the first time
+// it's needed, by this call or a query.
+func (e *QueryEngine) DominatorTree() *DominatorTree {
+	e.domOnce.Do(func() { e.dom = NewDominatorTree(e.h) })
+	return e.dom
+}
+
+// references calls fn with the index of each object referenced by the i-th
+// object.
+func (e *QueryEngine) references(i int, fn func(j int)) {
+	for _, to := range e.h.objects[i].References {
+		if j, ok := e.h.index[to]; ok {
+			fn(j)
+		}
+	}
+}
+
+// referrers calls fn with the index of each object referencing the i-th
+// object.
+func (e *QueryEngine) referrers(i int, fn func(j int)) {
+	for _, from := range e.h.Referrers(e.h.objects[i].Address) {
+		if j, ok := e.h.index[from]; ok {
+			fn(j)
+		}
+	}
+}
+
+// rootIndexes are the indexes of the objects referenced by the ROOT records
+// for which keep is true.
+func (e *QueryEngine) rootIndexes(keep func(root *RubyObject) bool) []int {
+	var seeds []int
+	for r := range e.h.roots {
+		if !keep(&e.h.roots[r]) {
+			continue
+		}
+		for _, to := range e.h.roots[r].References {
+			if i, ok := e.h.index[to]; ok {
+				seeds = append(seeds, i)
+			}
+		}
+	}
+	return seeds
+}
+
+// reach marks the objects reachable from the seeds by following references,
+// seeds included, never going through the objects marked in avoid.
+func (e *QueryEngine) reach(seeds []int, avoid []bool) []bool {
+	seen := make([]bool, len(e.h.objects))
+	var stack []int
+	for _, i := range seeds {
+		if !seen[i] && (avoid == nil || !avoid[i]) {
+			seen[i] = true
+			stack = append(stack, i)
+		}
+	}
+	for len(stack) != 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		e.references(i, func(j int) {
+			if !seen[j] && (avoid == nil || !avoid[j]) {
+				seen[j] = true
+				stack = append(stack, j)
+			}
+		})
+	}
+	return seen
+}
+
+func members(set []bool) []int {
+	var idx []int
+	for i, in := range set {
+		if in {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// Stages
+
+func allStage(e *QueryEngine, _ []bool) []bool {
+	set := make([]bool, len(e.h.objects))
+	for i := range set {
+		set[i] = true
+	}
+	return set
+}
+
+func rootsStage(category string) queryStage {
+	return func(e *QueryEngine, _ []bool) []bool {
+		set := make([]bool, len(e.h.objects))
+		for _, i := range e.rootIndexes(func(root *RubyObject) bool {
+			return category == "" || root.Root == category
+		}) {
+			set[i] = true
+		}
+		return set
+	}
+}
+
+func heldStage(category string) queryStage {
+	return func(e *QueryEngine, _ []bool) []bool {
+		held := e.reach(e.rootIndexes(func(root *RubyObject) bool { return root.Root == category }), nil)
+		others := e.reach(e.rootIndexes(func(root *RubyObject) bool { return root.Root != category }), nil)
+		for i := range held {
+			held[i] = held[i] && !others[i]
+		}
+		return held
+	}
+}
+
+func whereStage(filter *Filter) queryStage {
+	return func(e *QueryEngine, set []bool) []bool {
+		out := make([]bool, len(set))
+		for i, in := range set {
+			out[i] = in && filter.Match(&e.h.objects[i], e.h)
+		}
+		return out
+	}
+}
+
+// walkStage follows edges breadth first from the set, up to depth edges
+// away or without limit if depth is negative.  The objects of the set are
+// only kept if they can be reached from another one.
+func walkStage(edges func(e *QueryEngine, i int, fn func(j int)), depth int) queryStage {
+	return func(e *QueryEngine, set []bool) []bool {
+		out := make([]bool, len(set))
+		frontier := members(set)
+		for d := 0; len(frontier) != 0 && (depth < 0 || d < depth); d++ {
+			var next []int
+			for _, i := range frontier {
+				edges(e, i, func(j int) {
+					if !out[j] {
+						out[j] = true
+						next = append(next, j)
+					}
+				})
+			}
+			frontier = next
+		}
+		return out
+	}
+}
+
+func dominatedStage(e *QueryEngine, set []bool) []bool {
+	idom := e.DominatorTree().idom
+
+	// Walk up the dominator tree from each object until an object of the set
+	// or an object whose answer is known, and record the answer on the way.
+	const (
+		unknown = iota
+		yes
+		no
+	)
+	state := make([]byte, len(set))
+	var chain []int32
+	for i := range set {
+		chain = chain[:0]
+		answer := byte(no)
+		for j := int32(i); j >= 0; j = idom[j] {
+			if state[j] != unknown {
+				answer = state[j]
+				break
+			}
+			if set[j] {
+				state[j] = yes
+				answer = yes
+				break
+			}
+			chain = append(chain, j)
+		}
+		for _, j := range chain {
+			state[j] = answer
+		}
+	}
+
+	out := make([]bool, len(set))
+	for i := range out {
+		out[i] = state[i] == yes
+	}
+	return out
+}
+
+func retainedStage(e *QueryEngine, set []bool) []bool {
+	alive := e.reach(e.rootIndexes(func(*RubyObject) bool { return true }), set)
+	out := e.reach(members(set), nil)
+	for i := range out {
+		out[i] = out[i] && !alive[i]
+	}
+	return out
+}
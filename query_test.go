@@ -0,0 +1,136 @@
+package rubyobj_test
+
+import (
+	"github.com/aybabtme/rubyobj"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// queryDump has r held by the vm roots, g by global_tbl, s by both, and x by
+// none:
+//
+//	vm -> r -> a -> b -> c
+//	      r -> s
+//	global_tbl -> g -> h1 -> h2
+//	              g -> s
+//	x -> a
+const queryDump = `{"type":"ROOT", "root":"vm", "references":["0x7fc96c000001"]}
+{"type":"ROOT", "root":"global_tbl", "references":["0x7fc96c000005"]}
+{"address":"0x7fc96c000001", "type":"OBJECT", "references":["0x7fc96c000002", "0x7fc96c000008"]}
+{"address":"0x7fc96c000002", "type":"OBJECT", "class":"0x7fc96c000100", "references":["0x7fc96c000003"]}
+{"address":"0x7fc96c000003", "type":"OBJECT", "class":"0x7fc96c000100", "references":["0x7fc96c000004"]}
+{"address":"0x7fc96c000004", "type":"STRING", "value":"c"}
+{"address":"0x7fc96c000005", "type":"OBJECT", "references":["0x7fc96c000006", "0x7fc96c000008"]}
+{"address":"0x7fc96c000006", "type":"OBJECT", "references":["0x7fc96c000007"]}
+{"address":"0x7fc96c000007", "type":"OBJECT"}
+{"address":"0x7fc96c000008", "type":"OBJECT"}
+{"address":"0x7fc96c000009", "type":"OBJECT", "references":["0x7fc96c000002"]}
+{"address":"0x7fc96c000100", "type":"CLASS", "name":"Foo"}
+`
+
+func TestQuery(t *testing.T) {
+	const (
+		r   = 0x7fc96c000001
+		a   = 0x7fc96c000002
+		b   = 0x7fc96c000003
+		c   = 0x7fc96c000004
+		g   = 0x7fc96c000005
+		h1  = 0x7fc96c000006
+		h2  = 0x7fc96c000007
+		s   = 0x7fc96c000008
+		x   = 0x7fc96c000009
+		foo = 0x7fc96c000100
+	)
+	engine := rubyobj.NewQueryEngine(loadHeap(t, queryDump))
+
+	tests := []struct {
+		expr string
+		want []uint64
+	}{
+		{`all`, []uint64{r, a, b, c, g, h1, h2, s, x, foo}},
+		{`roots`, []uint64{r, g}},
+		{`roots vm`, []uint64{r}},
+		{`roots "global_tbl"`, []uint64{g}},
+		{`roots nope`, nil},
+		{`held vm`, []uint64{r, a, b, c}},
+		{`held global_tbl`, []uint64{g, h1, h2}},
+
+		{`where class_name == "Foo"`, []uint64{a, b}},
+		{`where type == STRING || class_name == "Foo"`, []uint64{a, b, c}},
+		{`where type == STRING || class_name == "Foo" | where type == OBJECT`, []uint64{a, b}},
+
+		// The objects of the set are only kept if they're reached.
+		{`where class_name == "Foo" | out`, []uint64{b, c}},
+		{`where class_name == "Foo" | out 1`, []uint64{b, c}},
+		{`roots vm | out 2`, []uint64{a, b, s}},
+		{`roots vm | out *`, []uint64{a, b, c, s}},
+		{`roots vm | reachable`, []uint64{a, b, c, s}},
+		{`where type == STRING | in`, []uint64{b}},
+		{`where type == STRING | in 2`, []uint64{a, b}},
+		{`where type == STRING | in *`, []uint64{r, a, b, x}},
+		{`where type == STRING|in|in`, []uint64{a}},
+
+		{`roots vm | dominated`, []uint64{r, a, b, c}},
+		{`where address == 0x7fc96c000002 | dominated`, []uint64{a, b, c}},
+		{`roots | dominated`, []uint64{r, a, b, c, g, h1, h2}},
+		{`where address == 0x7fc96c000002 | retained`, []uint64{a, b, c}},
+		{`roots vm | retained`, []uint64{r, a, b, c}},
+		{`roots | retained`, []uint64{r, a, b, c, g, h1, h2, s}},
+		{`where address == 0x7fc96c000009 | retained`, []uint64{x}},
+
+		{`all | where type == CLASS | out`, nil},
+	}
+	for _, tt := range tests {
+		got, err := engine.Query(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if len(got) != len(tt.want) || (len(got) != 0 && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("%s:\nwant %x\n got %x", tt.expr, tt.want, got)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{``, "expected a stage at offset 0"},
+		{`all |`, "expected a stage at offset 5"},
+		{`all | | all`, "expected a stage at offset 6"},
+		{`"all"`, "expected a stage at offset 0"},
+		{`nope`, `unknown stage "nope" at offset 0`},
+		{`all | nope`, `unknown stage "nope" at offset 6`},
+		{`all 1`, `all takes no argument, got "1" at offset 4`},
+		{`reachable 2`, `reachable takes no argument, got "2"`},
+		{`dominated x`, `dominated takes no argument`},
+		{`retained x`, `retained takes no argument`},
+		{`held`, "held needs a root category at offset 0"},
+		{`roots vm heap`, `roots takes a root category, got "vm"`},
+		{`held 1`, `held takes a root category, got "1"`},
+		{`out 0`, `bad depth "0" at offset 4`},
+		{`in 0x10000000000000000`, `bad depth`},
+		{`out vm`, `out takes a depth or *, got "vm"`},
+		{`in 1 2`, `in takes a depth or *, got "1"`},
+		{`where`, "unexpected end of expression"},
+		{`where nope | all`, `unknown field "nope"`},
+		{`where file == "x`, "unterminated string"},
+		{`all @`, `unexpected '@'`},
+	}
+	for _, tt := range tests {
+		_, err := rubyobj.CompileQuery(tt.expr)
+		if err == nil {
+			t.Errorf("%s: want an error", tt.expr)
+		} else if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: want an error about %q, got %v", tt.expr, tt.want, err)
+		}
+	}
+
+	engine := rubyobj.NewQueryEngine(loadHeap(t, queryDump))
+	if _, err := engine.Query(`nope`); err == nil {
+		t.Error("the engine ran an invalid query")
+	}
+}
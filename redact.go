@@ -0,0 +1,92 @@
+package rubyobj
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// RedactMode is how a Redactor replaces the value of strings.
+type RedactMode int
+
+const (
+	// RedactHash replaces values with a keyed hash of them, so that equal
+	// strings still look equal, like for DuplicateStrings.
+	RedactHash RedactMode = iota
+	// RedactPlaceholder replaces values with as many x as they had bytes.
+	RedactPlaceholder
+)
+
+// RedactRule picks strings to redact.  All the fields that are set must
+// match; a zero rule matches all strings.
+type RedactRule struct {
+	// Class is the name of the class of the strings, like
+	// "ActiveSupport::SafeBuffer".
+	Class string
+	// File matches the file the strings were allocated in.
+	File *regexp.Regexp
+	// Value matches the value of the strings.
+	Value *regexp.Regexp
+}
+
+func (rule *RedactRule) match(rObj *RubyObject, classes ClassNamer) bool {
+	if rule.Class != "" {
+		if classes == nil {
+			return false
+		}
+		if classes.ClassName(rObj) != rule.Class {
+			return false
+		}
+	}
+	if rule.File != nil && !rule.File.MatchString(rObj.File) {
+		return false
+	}
+	if rule.Value != nil && !rule.Value.MatchString(rObj.Value.(string)) {
+		return false
+	}
+	return true
+}
+
+// Redactor replaces the value of the strings matching any of its rules.
+// Only values change: Bytesize, addresses and references are left alone, so
+// that sizes and the graph of a redacted dump are the same as the original.
+type Redactor struct {
+	Rules []RedactRule
+	Mode  RedactMode
+	// Key keys the hashes of RedactHash, so that short values can't be
+	// guessed by hashing candidates.  Keep it secret.
+	Key []byte
+	// Classes names the classes of Class rules.
+	Classes ClassNamer
+}
+
+// Redact redacts the value of rObj if it matches a rule.  It can be used as a
+// Pipeline transform.
+func (r *Redactor) Redact(rObj *RubyObject) {
+	if rObj.Type != String {
+		return
+	}
+	value, ok := rObj.Value.(string)
+	if !ok || value == "" {
+		return
+	}
+	for i := range r.Rules {
+		if r.Rules[i].match(rObj, r.Classes) {
+			rObj.Value = r.replace(value)
+			return
+		}
+	}
+}
+
+func (r *Redactor) replace(value string) string {
+	switch r.Mode {
+	case RedactPlaceholder:
+		return strings.Repeat("x", len(value))
+	default:
+		mac := hmac.New(sha256.New, r.Key)
+		mac.Write([]byte(value))
+		return hex.EncodeToString(mac.Sum(nil)[:8])
+	}
+}
@@ -0,0 +1,126 @@
+package rubyobj_test
+
+import (
+	"github.com/aybabtme/rubyobj"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func redactedValue(r *rubyobj.Redactor, value string) string {
+	rObj := rubyobj.RubyObject{Type: rubyobj.String, Value: value, Bytesize: uint64(len(value))}
+	r.Redact(&rObj)
+	return rObj.Value.(string)
+}
+
+func TestRedactHash(t *testing.T) {
+	one := &rubyobj.Redactor{Rules: []rubyobj.RedactRule{{}}, Key: []byte("one")}
+	oneAgain := &rubyobj.Redactor{Rules: []rubyobj.RedactRule{{}}, Key: []byte("one")}
+	two := &rubyobj.Redactor{Rules: []rubyobj.RedactRule{{}}, Key: []byte("two")}
+
+	hash := redactedValue(one, "secret")
+	if hash == "secret" || len(hash) != 16 || strings.Trim(hash, "0123456789abcdef") != "" {
+		t.Fatalf("want 16 hex digits, got %q", hash)
+	}
+	if got := redactedValue(one, "secret"); got != hash {
+		t.Errorf("the same redactor hashed secret to %q then %q", hash, got)
+	}
+	if got := redactedValue(oneAgain, "secret"); got != hash {
+		t.Errorf("the same key hashed secret to %q then %q", hash, got)
+	}
+	if got := redactedValue(two, "secret"); got == hash {
+		t.Errorf("both keys hashed secret to %q", got)
+	}
+	if got := redactedValue(one, "secret2"); got == hash {
+		t.Errorf("secret and secret2 were both hashed to %q", got)
+	}
+}
+
+func TestRedactHashKeepsDuplicates(t *testing.T) {
+	// Equal strings still look equal, and different ones different.
+	r := &rubyobj.Redactor{Rules: []rubyobj.RedactRule{{}}, Key: []byte("key")}
+	before, after := make(map[string]bool), make(map[string]bool)
+	pairs := make(map[string]string)
+	for _, rObj := range decodeFile(t, "testdata/small.json") {
+		value, ok := rObj.Value.(string)
+		if rObj.Type != rubyobj.String || !ok || value == "" {
+			continue
+		}
+		r.Redact(&rObj)
+		if rObj.Value == value {
+			t.Fatalf("%q wasn't redacted", value)
+		}
+		if was, ok := pairs[value]; ok && was != rObj.Value {
+			t.Fatalf("%q was hashed to %q and %q", value, was, rObj.Value)
+		}
+		pairs[value] = rObj.Value.(string)
+		before[value] = true
+		after[rObj.Value.(string)] = true
+	}
+	if len(before) == 0 || len(before) != len(after) {
+		t.Errorf("%d distinct values became %d", len(before), len(after))
+	}
+}
+
+func TestRedactRules(t *testing.T) {
+	objs := decodeDump(t, strings.NewReader(`{"address":"0x7fc96c000100", "type":"CLASS", "name":"String"}
+{"address":"0x7fc96c000200", "type":"CLASS", "name":"SafeBuffer"}
+{"address":"0x7fc96c000001", "type":"STRING", "class":"0x7fc96c000100", "value":"password=hunter2", "bytesize":16, "file":"/app/config.rb", "references":["0x7fc96c000002"]}
+{"address":"0x7fc96c000002", "type":"STRING", "class":"0x7fc96c000200", "value":"<p>hello</p>", "bytesize":12, "file":"/app/views/x.erb"}
+{"address":"0x7fc96c000003", "type":"STRING", "class":"0x7fc96c000100", "value":"", "file":"/app/config.rb"}
+{"address":"0x7fc96c000004", "type":"SYMBOL", "value":"password", "file":"/app/config.rb"}
+`))
+	classes := rubyobj.NewClassIndex()
+	for i := range objs {
+		classes.Add(&objs[i])
+	}
+	objs = objs[2:]
+
+	tests := []struct {
+		name  string
+		rules []rubyobj.RedactRule
+		want  [2]bool // the password, the buffer
+	}{
+		{"no rule", nil, [2]bool{false, false}},
+		{"zero rule", []rubyobj.RedactRule{{}}, [2]bool{true, true}},
+		{"class", []rubyobj.RedactRule{{Class: "SafeBuffer"}}, [2]bool{false, true}},
+		{"file", []rubyobj.RedactRule{{File: regexp.MustCompile(`config\.rb$`)}}, [2]bool{true, false}},
+		{"value", []rubyobj.RedactRule{{Value: regexp.MustCompile(`^password=`)}}, [2]bool{true, false}},
+		{"all fields", []rubyobj.RedactRule{{Class: "String", File: regexp.MustCompile(`config`), Value: regexp.MustCompile(`hunter`)}}, [2]bool{true, false}},
+		{"not all fields", []rubyobj.RedactRule{{Class: "SafeBuffer", File: regexp.MustCompile(`config`)}}, [2]bool{false, false}},
+		{"any rule", []rubyobj.RedactRule{{Class: "SafeBuffer"}, {Value: regexp.MustCompile(`hunter`)}}, [2]bool{true, true}},
+	}
+	for _, tt := range tests {
+		for _, mode := range []rubyobj.RedactMode{rubyobj.RedactHash, rubyobj.RedactPlaceholder} {
+			r := &rubyobj.Redactor{Rules: tt.rules, Mode: mode, Key: []byte("key"), Classes: classes}
+			for i := range objs {
+				rObj := objs[i]
+				r.Redact(&rObj)
+
+				redacted := rObj.Value != objs[i].Value
+				if i < 2 && redacted != tt.want[i] {
+					t.Errorf("%s, mode %d: want object %d redacted %v, got %q", tt.name, mode, i, tt.want[i], rObj.Value)
+				}
+				// Empty strings and other types are never redacted.
+				if i >= 2 && redacted {
+					t.Errorf("%s, mode %d: object %d was redacted to %q", tt.name, mode, i, rObj.Value)
+				}
+				if redacted && mode == rubyobj.RedactPlaceholder && rObj.Value != strings.Repeat("x", len(objs[i].Value.(string))) {
+					t.Errorf("%s: want a placeholder, got %q", tt.name, rObj.Value)
+				}
+				rObj.Value = objs[i].Value
+				if !sameObject(rObj, objs[i]) {
+					t.Errorf("%s, mode %d: more than the value changed: %+v", tt.name, mode, rObj)
+				}
+			}
+		}
+	}
+
+	// Without classes, class rules match nothing.
+	r := &rubyobj.Redactor{Rules: []rubyobj.RedactRule{{Class: "SafeBuffer"}}}
+	rObj := objs[1]
+	r.Redact(&rObj)
+	if rObj.Value != objs[1].Value {
+		t.Errorf("redacted %q without knowing its class", objs[1].Value)
+	}
+}
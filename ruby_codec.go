@@ -8,6 +8,7 @@ import (
 	"github.com/aybabtme/fatherhood"
 	"io"
 	// "log"
+	"strings"
 	"sync"
 )
 
@@ -44,13 +45,14 @@ func (d *Decoder) Decode(rObj *RubyObject) (err error) {
 // pretty slow, but simple to use for those acustomed to the json.Encoder from
 // the stdlib.
 type Encoder struct {
+	w   io.Writer
 	enc *json.Encoder
 }
 
 // NewEncoder returns a trivial encoder wrapping a json.Encoder of the stdlib.
 // It is pretty slow but simple to use.
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{json.NewEncoder(w)}
+	return &Encoder{w, json.NewEncoder(w)}
 }
 
 // Encode encodes the object onto the underlying io.Writer.
@@ -58,6 +60,24 @@ func (e *Encoder) Encode(rObj *RubyObject) (err error) {
 	return e.enc.Encode(rObj.saveSchema())
 }
 
+// EncodeRecord encodes the record onto the underlying io.Writer.  Unlike
+// Encode, the members of the original line that RubyObject doesn't know about
+// are kept, and an unmodified record is written exactly as it was read.
+func (e *Encoder) EncodeRecord(rec *Record) error {
+	line := bytes.TrimRight(rec.raw, "\r\n")
+	if rec.modified() {
+		var err error
+		if line, err = rec.marshal(); err != nil {
+			return err
+		}
+	}
+	if _, err := e.w.Write(line); err != nil {
+		return err
+	}
+	_, err := e.w.Write([]byte{'\n'})
+	return err
+}
+
 // Parallel
 
 // ParallelDecode will use many goroutines to decode io.Reader.  io.Reader MUST
@@ -133,35 +153,82 @@ func decodeLines(wg *sync.WaitGroup, lineC <-chan []byte, decoded chan<- RubyObj
 	defer wg.Done()
 
 	rObj := RubyObject{}
-	schema := objectSchema{}
-	var err error
-
-	r := bytes.NewBuffer(nil)
-	dec := fatherhood.NewDecoder(r)
+	dec := newLineDecoder()
 
 	for line := range lineC {
-
-		_, _ = r.Write(line)
-		err = dec.EachMember(&schema, decodeObjSchema)
-		if err != nil {
-			errc <- err
-			continue
-		}
-
-		err = rObj.loadSchema(&schema)
-		if err != nil {
+		if err := dec.decode(line, &rObj); err != nil {
 			errc <- err
 			continue
 		}
 		decoded <- rObj
+	}
 
+}
+
+// lineDecoder decodes single lines of a dump with a fatherhood decoder,
+// reused from one line to the next.
+type lineDecoder struct {
+	r      *bytes.Buffer
+	dec    *fatherhood.Decoder
+	schema objectSchema
+}
+
+func newLineDecoder() *lineDecoder {
+	r := bytes.NewBuffer(nil)
+	return &lineDecoder{r: r, dec: fatherhood.NewDecoder(r)}
+}
+
+func (l *lineDecoder) decode(line []byte, rObj *RubyObject) error {
+	// What follows the object of a line would be decoded as the next one.
+	if end := valueEnd(line); end >= 0 && len(bytes.TrimSpace(line[end:])) != 0 {
+		return fmt.Errorf("unexpected data after the object: %q", line[end:])
+	}
+	_, _ = l.r.Write(line)
+	l.schema.clear()
+	if err := l.dec.EachMember(&l.schema, decodeObjSchema); err != nil {
+		// Don't let what's left of a bad line spill onto the next one.
+		l.r = bytes.NewBuffer(nil)
+		l.dec = fatherhood.NewDecoder(l.r)
+		return err
 	}
+	return rObj.loadSchema(&l.schema)
+}
 
+// valueEnd is the index right after the object or array that starts line, or
+// -1 if it doesn't end on the line.  It only follows brackets and strings,
+// the decoder checks the rest.
+func valueEnd(line []byte) int {
+	depth := 0
+	inString, escaped := false, false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
 }
 
 func decodeObjSchema(dec *fatherhood.Decoder, s interface{}, member string) error {
 	schema := s.(*objectSchema)
 	switch member {
+	case "root":
+		return dec.ReadString(&schema.Root)
 	case "address":
 		return dec.ReadString(&schema.Address)
 	case "class":
@@ -216,9 +283,11 @@ func decodeObjSchema(dec *fatherhood.Decoder, s interface{}, member string) erro
 	case "shared":
 		return dec.ReadBool(&schema.Shared)
 	case "flags":
-		schema.Flags = flagSchema{}
 		return dec.EachMember(&schema.Flags, decodeFlagSchema)
 	}
+	if key, ok := foldMember(member, objectKeys); ok {
+		return decodeObjSchema(dec, s, key)
+	}
 	// unsupported member
 	return dec.Discard()
 }
@@ -233,10 +302,36 @@ func decodeFlagSchema(dec *fatherhood.Decoder, f interface{}, member string) err
 	case "marked":
 		return dec.ReadBool(&flag.Marked)
 	}
+	if key, ok := foldMember(member, flagKeys); ok {
+		return decodeFlagSchema(dec, f, key)
+	}
 	// unsupported member
 	return dec.Discard()
 }
 
+// The members decodeObjSchema and decodeFlagSchema know about.
+var (
+	objectKeys = []string{
+		"root", "address", "class", "node_type", "references", "type", "value",
+		"line", "method", "file", "fd", "bytesize", "capacity", "length", "size",
+		"encoding", "default", "name", "struct", "ivars", "generation", "memsize",
+		"frozen", "embedded", "broken", "fstring", "shared", "flags",
+	}
+	flagKeys = []string{"wb_protected", "old", "marked"}
+)
+
+// foldMember finds the known member that member is, regardless of case, like
+// encoding/json matches keys to fields.  Dumps only have lowercase members, so
+// it's only tried for those that aren't known as is.
+func foldMember(member string, known []string) (string, bool) {
+	for _, key := range known {
+		if strings.EqualFold(member, key) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
 func decodeReference(dec *fatherhood.Decoder, a interface{}, t fatherhood.JSONType) error {
 	arr := a.(*[]string)
 	switch t {
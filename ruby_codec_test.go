@@ -0,0 +1,37 @@
+package rubyobj_test
+
+import (
+	"github.com/aybabtme/rubyobj"
+	"strings"
+	"testing"
+)
+
+func TestParallelDecodeDoesntLeakFields(t *testing.T) {
+	// The second line has none of the members of the first, so it must come
+	// out with none of their values.
+	dump := `{"address":"0x7fc96c8337a8", "type":"STRING", "class":"0x7fc96b8ab908", "frozen":true, "bytesize":3, "value":"abc", "encoding":"UTF-8", "file":"app.rb", "line":12, "method":"new", "generation":7, "memsize":40, "flags":{"wb_protected":true, "old":true}}
+{"address":"0x7fc96c8337d0", "type":"OBJECT"}
+`
+	objC, errC := rubyobj.ParallelDecode(strings.NewReader(dump), 1)
+	var objs []rubyobj.RubyObject
+	for rObj := range objC {
+		objs = append(objs, rObj)
+	}
+	for err := range errC {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 {
+		t.Fatalf("want 2 objects, got %d", len(objs))
+	}
+
+	if objs[0].Value != "abc" || objs[0].File != "app.rb" || !objs[0].GcOld() {
+		t.Fatalf("first object wasn't decoded: %+v", objs[0])
+	}
+	got := objs[1]
+	if got.Type != rubyobj.Object || got.Address != 0x7fc96c8337d0 || got.Class != 0 ||
+		got.Value != "" || got.File != "" || got.Line != 0 || got.Method != "" ||
+		got.Generation != 0 || got.Memsize != 0 || got.Bytesize != 0 || got.Encoding != "" ||
+		got.Frozen() || got.GcOld() || got.GcWbProtected() {
+		t.Errorf("fields of the first object leaked into the second: %+v", got)
+	}
+}
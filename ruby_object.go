@@ -14,6 +14,8 @@ type RubyObject struct {
 	Name  string
 
 	NodeType string
+	// Root is the category of the references of a ROOT record, like "vm".
+	Root string
 
 	Address    uint64
 	Class      uint64
@@ -100,6 +102,7 @@ func (r *RubyObject) loadSchema(schema *objectSchema) error {
 	accumulate(err)
 
 	r.NodeType = schema.NodeType
+	r.Root = schema.Root
 
 	r.Line = schema.Line
 	r.Method = schema.Method
@@ -141,6 +144,7 @@ func (r *RubyObject) loadSchema(schema *objectSchema) error {
 
 func (ro *RubyObject) saveSchema() (schema *objectSchema) {
 	return &objectSchema{
+		Root:       ro.Root,
 		Address:    formatUint64(ro.Address),
 		Class:      formatUint64(ro.Class),
 		NodeType:   ro.NodeType,
@@ -175,6 +179,17 @@ func (ro *RubyObject) saveSchema() (schema *objectSchema) {
 	}
 }
 
+// ParseAddress parses an object address as found in ObjectSpace dumps, like
+// "0x7fc96c8337a8".  The "0x" prefix is optional.
+func ParseAddress(addr string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimPrefix(addr, "0x"), 16, 64)
+}
+
+// FormatAddress formats an object address the way ObjectSpace dumps do.
+func FormatAddress(addr uint64) string {
+	return formatUint64(addr)
+}
+
 func parseHexUint64(hexStr string) (uint64, error) {
 	if len(hexStr) != 14 {
 		return 0, nil
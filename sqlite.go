@@ -0,0 +1,192 @@
+package rubyobj
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// sqliteBatchSize is how many objects are inserted per transaction.
+const sqliteBatchSize = 10000
+
+// SQLiteWriter writes RubyObjects into an SQLite database, in four tables:
+//
+//	objects: one row per object, one column per field like TableWriter
+//	edges:   from_address, to_address and ordinal of each reference
+//	roots:   root category and address of the objects held by ROOT records
+//	classes: address and name of the classes and modules
+//
+// The tables are created by NewSQLiteWriter and their indexes by Close, once
+// all the rows are inserted.  The database is any *sql.DB using an SQLite
+// driver, such as github.com/mattn/go-sqlite3.
+type SQLiteWriter struct {
+	db    *sql.DB
+	stmts sqliteStmts
+
+	// tx is the transaction in progress, and txStmts the statements
+	// prepared for it.
+	tx      *sql.Tx
+	txStmts sqliteStmts
+
+	pending int
+	row     []interface{}
+}
+
+type sqliteStmts struct {
+	objects *sql.Stmt
+	edges   *sql.Stmt
+	roots   *sql.Stmt
+	classes *sql.Stmt
+}
+
+func (s sqliteStmts) forTx(tx *sql.Tx) sqliteStmts {
+	return sqliteStmts{
+		objects: tx.Stmt(s.objects),
+		edges:   tx.Stmt(s.edges),
+		roots:   tx.Stmt(s.roots),
+		classes: tx.Stmt(s.classes),
+	}
+}
+
+func (s sqliteStmts) close() {
+	s.objects.Close()
+	s.edges.Close()
+	s.roots.Close()
+	s.classes.Close()
+}
+
+func sqliteType(col column) string {
+	switch col.typ {
+	case "long", "boolean":
+		return "INTEGER"
+	default:
+		return "TEXT"
+	}
+}
+
+// NewSQLiteWriter creates the tables in db, which should be empty.
+func NewSQLiteWriter(db *sql.DB) (*SQLiteWriter, error) {
+	var defs, names, params []string
+	for _, col := range objectColumns {
+		defs = append(defs, fmt.Sprintf("%q %s", col.name, sqliteType(col)))
+		names = append(names, fmt.Sprintf("%q", col.name))
+		params = append(params, "?")
+	}
+
+	stmts := []string{
+		"CREATE TABLE objects (" + strings.Join(defs, ", ") + ")",
+		"CREATE TABLE edges (from_address TEXT, to_address TEXT, ordinal INTEGER)",
+		"CREATE TABLE roots (root TEXT, address TEXT)",
+		"CREATE TABLE classes (address TEXT, name TEXT)",
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &SQLiteWriter{db: db, row: make([]interface{}, len(objectColumns))}
+	var err error
+	prepare := func(query string) *sql.Stmt {
+		if err != nil {
+			return nil
+		}
+		var stmt *sql.Stmt
+		stmt, err = db.Prepare(query)
+		return stmt
+	}
+	s.stmts.objects = prepare("INSERT INTO objects (" + strings.Join(names, ", ") + ") VALUES (" + strings.Join(params, ", ") + ")")
+	s.stmts.edges = prepare("INSERT INTO edges VALUES (?, ?, ?)")
+	s.stmts.roots = prepare("INSERT INTO roots VALUES (?, ?)")
+	s.stmts.classes = prepare("INSERT INTO classes VALUES (?, ?)")
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Write inserts rObj, its references and, if it's a class or a module, its
+// name.  Objects are inserted in transactions of many objects.
+func (s *SQLiteWriter) Write(rObj *RubyObject) error {
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		s.tx = tx
+		s.txStmts = s.stmts.forTx(tx)
+	}
+
+	for i, col := range objectColumns {
+		s.row[i] = typedValue(col, rObj)
+	}
+	if _, err := s.txStmts.objects.Exec(s.row...); err != nil {
+		return err
+	}
+
+	if rObj.Type == Root {
+		for _, to := range rObj.References {
+			if _, err := s.txStmts.roots.Exec(rObj.Root, FormatAddress(to)); err != nil {
+				return err
+			}
+		}
+	} else {
+		from := FormatAddress(rObj.Address)
+		for i, to := range rObj.References {
+			if _, err := s.txStmts.edges.Exec(from, FormatAddress(to), i); err != nil {
+				return err
+			}
+		}
+	}
+
+	if (rObj.Type == Class || rObj.Type == Module) && rObj.Name != "" {
+		if _, err := s.txStmts.classes.Exec(FormatAddress(rObj.Address), rObj.Name); err != nil {
+			return err
+		}
+	}
+
+	s.pending++
+	if s.pending >= sqliteBatchSize {
+		return s.commit()
+	}
+	return nil
+}
+
+// commit ends the transaction in progress.  Its statements are closed
+// whether or not it could be committed.
+func (s *SQLiteWriter) commit() error {
+	err := s.tx.Commit()
+	s.txStmts.close()
+	s.tx = nil
+	s.pending = 0
+	return err
+}
+
+// Close commits the last objects and indexes the tables on addresses,
+// classes and allocation sites.  It doesn't close the database.
+func (s *SQLiteWriter) Close() error {
+	var err error
+	if s.tx != nil {
+		err = s.commit()
+	}
+	s.stmts.close()
+	if err != nil {
+		return err
+	}
+
+	indexes := []string{
+		"CREATE INDEX objects_address ON objects (address)",
+		"CREATE INDEX objects_class ON objects (class)",
+		"CREATE INDEX objects_site ON objects (file, line)",
+		"CREATE INDEX edges_from ON edges (from_address)",
+		"CREATE INDEX edges_to ON edges (to_address)",
+		"CREATE INDEX roots_address ON roots (address)",
+		"CREATE INDEX classes_address ON classes (address)",
+	}
+	for _, index := range indexes {
+		if _, err := s.db.Exec(index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
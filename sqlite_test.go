@@ -0,0 +1,138 @@
+package rubyobj
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func queryStrings(t *testing.T, db *sql.DB, query string) []string {
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func queryCount(t *testing.T, db *sql.DB, table string) int {
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSQLiteWriter(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "heap.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A class, then a chain of more objects than fit in a transaction.
+	const class = 0x7fc96c000000
+	n := sqliteBatchSize + 2
+	addr := func(i int) uint64 { return class + uint64(i+1)*40 }
+	objs := []RubyObject{
+		{Type: Root, Root: "vm", References: []uint64{addr(0)}},
+		{Type: Root, Root: "machine_context", References: []uint64{class, addr(1)}},
+		{Address: class, Type: Class, Name: "Foo", Memsize: 100},
+	}
+	for i := 0; i < n; i++ {
+		rObj := RubyObject{Address: addr(i), Type: Object, Class: class, Memsize: uint64(i)}
+		if i < n-1 {
+			rObj.References = []uint64{addr(i + 1)}
+		}
+		objs = append(objs, rObj)
+	}
+
+	w, err := NewSQLiteWriter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range objs {
+		if err := w.Write(&objs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the first transaction is committed until Close.
+	if got := queryCount(t, db, "objects"); got != sqliteBatchSize {
+		t.Errorf("want %d objects before Close, got %d", sqliteBatchSize, got)
+	}
+	if got := queryStrings(t, db, "SELECT name FROM sqlite_master WHERE type = 'index'"); len(got) != 0 {
+		t.Errorf("want no index before Close, got %v", got)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := queryCount(t, db, "objects"); got != len(objs) {
+		t.Errorf("want %d objects, got %d", len(objs), got)
+	}
+	if got := queryCount(t, db, "edges"); got != n-1 {
+		t.Errorf("want %d edges, got %d", n-1, got)
+	}
+
+	var (
+		typ, cls string
+		memsize  int64
+		embedded bool
+	)
+	last := FormatAddress(addr(n - 1))
+	err = db.QueryRow("SELECT type, class, memsize, embedded FROM objects WHERE address = ?", last).Scan(&typ, &cls, &memsize, &embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != "OBJECT" || cls != FormatAddress(class) || memsize != int64(n-1) || embedded {
+		t.Errorf("want the last object read back, got %s %s %d %v", typ, cls, memsize, embedded)
+	}
+
+	var from string
+	var ordinal int
+	if err := db.QueryRow("SELECT from_address, ordinal FROM edges WHERE to_address = ?", last).Scan(&from, &ordinal); err != nil {
+		t.Fatal(err)
+	}
+	if from != FormatAddress(addr(n-2)) || ordinal != 0 {
+		t.Errorf("want the last object referenced by the one before, got %s at %d", from, ordinal)
+	}
+
+	// ROOT records are objects without an address, and their references
+	// go to the roots table instead of the edges table.
+	if got := queryStrings(t, db, "SELECT root || ' ' || address FROM objects WHERE type = 'ROOT' ORDER BY rowid"); !reflect.DeepEqual(got, []string{"vm ", "machine_context "}) {
+		t.Errorf("want the ROOT records, got %q", got)
+	}
+	wantRoots := []string{
+		"vm " + FormatAddress(addr(0)),
+		"machine_context " + FormatAddress(class),
+		"machine_context " + FormatAddress(addr(1)),
+	}
+	if got := queryStrings(t, db, "SELECT root || ' ' || address FROM roots ORDER BY rowid"); !reflect.DeepEqual(got, wantRoots) {
+		t.Errorf("want roots %q, got %q", wantRoots, got)
+	}
+	if got := queryCount(t, db, "edges WHERE from_address = ''"); got != 0 {
+		t.Errorf("want no edge from a ROOT record, got %d", got)
+	}
+
+	if got := queryStrings(t, db, "SELECT address || ' ' || name FROM classes"); !reflect.DeepEqual(got, []string{FormatAddress(class) + " Foo"}) {
+		t.Errorf("want the class, got %q", got)
+	}
+
+	wantIndexes := []string{"classes_address", "edges_from", "edges_to", "objects_address", "objects_class", "objects_site", "roots_address"}
+	if got := queryStrings(t, db, "SELECT name FROM sqlite_master WHERE type = 'index' ORDER BY name"); !reflect.DeepEqual(got, wantIndexes) {
+		t.Errorf("want indexes %v, got %v", wantIndexes, got)
+	}
+}
@@ -0,0 +1,207 @@
+package rubyobj
+
+import (
+	"sort"
+)
+
+// Percentiles summarize a distribution of values.
+type Percentiles struct {
+	Min  uint64  `json:"min"`
+	P50  uint64  `json:"p50"`
+	P90  uint64  `json:"p90"`
+	P99  uint64  `json:"p99"`
+	Max  uint64  `json:"max"`
+	Mean float64 `json:"mean"`
+}
+
+func percentiles(values []uint64) Percentiles {
+	if len(values) == 0 {
+		return Percentiles{}
+	}
+	sort.Sort(uint64s(values))
+	var sum float64
+	for _, v := range values {
+		sum += float64(v)
+	}
+	at := func(p int) uint64 {
+		return values[(len(values)-1)*p/100]
+	}
+	return Percentiles{
+		Min:  values[0],
+		P50:  at(50),
+		P90:  at(90),
+		P99:  at(99),
+		Max:  values[len(values)-1],
+		Mean: sum / float64(len(values)),
+	}
+}
+
+type uint64s []uint64
+
+func (u uint64s) Len() int           { return len(u) }
+func (u uint64s) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+func (u uint64s) Less(i, j int) bool { return u[i] < u[j] }
+
+// TypeStat is the number of objects of a type and their memsize.
+type TypeStat struct {
+	Type    string `json:"type"`
+	Count   uint64 `json:"count"`
+	Memsize uint64 `json:"memsize"`
+}
+
+// RootStat is the number of references a root category holds.
+type RootStat struct {
+	Category   string `json:"category"`
+	References uint64 `json:"references"`
+}
+
+// FlagCounts counts the objects with each flag set.
+type FlagCounts struct {
+	Frozen      uint64 `json:"frozen"`
+	Old         uint64 `json:"old"`
+	WbProtected uint64 `json:"wb_protected"`
+	Shared      uint64 `json:"shared"`
+	Embedded    uint64 `json:"embedded"`
+}
+
+// Stats sums up what a dump is made of.
+type Stats struct {
+	Objects    uint64 `json:"objects"`
+	Memsize    uint64 `json:"memsize"`
+	References uint64 `json:"references"`
+
+	// Types are sorted by memsize, biggest first.
+	Types []TypeStat `json:"types"`
+	// Roots are sorted by category.
+	Roots []RootStat `json:"roots"`
+	Flags FlagCounts `json:"flags"`
+
+	// Degrees count references between objects, like Heap.Referrers, so the
+	// references of ROOT records aren't in the in-degrees.
+	MemsizePercentiles   Percentiles `json:"memsize_percentiles"`
+	OutDegreePercentiles Percentiles `json:"out_degree_percentiles"`
+	InDegreePercentiles  Percentiles `json:"in_degree_percentiles"`
+
+	// Generations are of the objects whose allocation was traced.
+	Generations        Percentiles `json:"generations"`
+	WithoutGenerations uint64      `json:"without_generation"`
+}
+
+// StatsReport computes the Stats of a dump.  Objects are fed one by one with
+// Add, so it can be used directly on the output of ParallelDecode.
+type StatsReport struct {
+	stats Stats
+
+	types       map[RubyType]*TypeStat
+	roots       map[string]uint64
+	addrs       []uint64
+	memsizes    []uint64
+	outDegrees  []uint64
+	inDegrees   map[uint64]uint64
+	generations []uint64
+}
+
+// NewStatsReport creates an empty report.
+func NewStatsReport() *StatsReport {
+	return &StatsReport{
+		types:     make(map[RubyType]*TypeStat),
+		roots:     make(map[string]uint64),
+		inDegrees: make(map[uint64]uint64),
+	}
+}
+
+// Add accounts for rObj in the report.
+func (r *StatsReport) Add(rObj *RubyObject) {
+	if rObj.Type == Root {
+		r.roots[rObj.Root] += uint64(len(rObj.References))
+		return
+	}
+	for _, ref := range rObj.References {
+		r.inDegrees[ref]++
+	}
+
+	s := &r.stats
+	s.Objects++
+	s.Memsize += rObj.Memsize
+	s.References += uint64(len(rObj.References))
+
+	ts, ok := r.types[rObj.Type]
+	if !ok {
+		ts = &TypeStat{Type: rObj.Type.Name()}
+		r.types[rObj.Type] = ts
+	}
+	ts.Count++
+	ts.Memsize += rObj.Memsize
+
+	if rObj.Frozen() {
+		s.Flags.Frozen++
+	}
+	if rObj.GcOld() {
+		s.Flags.Old++
+	}
+	if rObj.GcWbProtected() {
+		s.Flags.WbProtected++
+	}
+	if rObj.Shared() {
+		s.Flags.Shared++
+	}
+	if rObj.Embedded() {
+		s.Flags.Embedded++
+	}
+
+	if traced(rObj) {
+		r.generations = append(r.generations, rObj.Generation)
+	} else {
+		s.WithoutGenerations++
+	}
+
+	r.addrs = append(r.addrs, rObj.Address)
+	r.memsizes = append(r.memsizes, rObj.Memsize)
+	r.outDegrees = append(r.outDegrees, uint64(len(rObj.References)))
+}
+
+// Stats returns the stats of the objects added so far.
+func (r *StatsReport) Stats() Stats {
+	s := r.stats
+
+	s.Types = make([]TypeStat, 0, len(r.types))
+	for _, ts := range r.types {
+		s.Types = append(s.Types, *ts)
+	}
+	sort.Sort(typesByMemsize(s.Types))
+
+	s.Roots = make([]RootStat, 0, len(r.roots))
+	for category, refs := range r.roots {
+		s.Roots = append(s.Roots, RootStat{category, refs})
+	}
+	sort.Sort(rootsByCategory(s.Roots))
+
+	inDegrees := make([]uint64, len(r.addrs))
+	for i, addr := range r.addrs {
+		inDegrees[i] = r.inDegrees[addr]
+	}
+
+	// Percentiles sort their values, so sort copies to keep adding.
+	s.MemsizePercentiles = percentiles(append([]uint64(nil), r.memsizes...))
+	s.OutDegreePercentiles = percentiles(append([]uint64(nil), r.outDegrees...))
+	s.InDegreePercentiles = percentiles(inDegrees)
+	s.Generations = percentiles(append([]uint64(nil), r.generations...))
+	return s
+}
+
+type typesByMemsize []TypeStat
+
+func (t typesByMemsize) Len() int      { return len(t) }
+func (t typesByMemsize) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t typesByMemsize) Less(i, j int) bool {
+	if t[i].Memsize != t[j].Memsize {
+		return t[i].Memsize > t[j].Memsize
+	}
+	return t[i].Type < t[j].Type
+}
+
+type rootsByCategory []RootStat
+
+func (r rootsByCategory) Len() int           { return len(r) }
+func (r rootsByCategory) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r rootsByCategory) Less(i, j int) bool { return r[i].Category < r[j].Category }
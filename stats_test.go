@@ -0,0 +1,94 @@
+package rubyobj_test
+
+import (
+	"github.com/aybabtme/rubyobj"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStatsPercentiles(t *testing.T) {
+	report := rubyobj.NewStatsReport()
+	if got := report.Stats().MemsizePercentiles; got != (rubyobj.Percentiles{}) {
+		t.Errorf("no object: want zero percentiles, got %+v", got)
+	}
+
+	objs := decodeDump(t, strings.NewReader(`{"address":"0x7fc96c000001", "type":"OBJECT", "memsize":40}
+`))
+	report.Add(&objs[0])
+	want := rubyobj.Percentiles{Min: 40, P50: 40, P90: 40, P99: 40, Max: 40, Mean: 40}
+	if got := report.Stats().MemsizePercentiles; got != want {
+		t.Errorf("one object: want %+v, got %+v", want, got)
+	}
+
+	for i := 0; i < 99; i++ {
+		rObj := rubyobj.RubyObject{Type: rubyobj.Object, Memsize: uint64(i)}
+		report.Add(&rObj)
+	}
+	// 0 to 98, and 40 twice.
+	want = rubyobj.Percentiles{Min: 0, P50: 48, P90: 88, P99: 97, Max: 98, Mean: 48.91}
+	if got := report.Stats().MemsizePercentiles; got != want {
+		t.Errorf("100 objects: want %+v, got %+v", want, got)
+	}
+}
+
+func TestStatsDegreesAndGenerations(t *testing.T) {
+	objs := decodeDump(t, strings.NewReader(`{"type":"ROOT", "root":"vm", "references":["0x7fc96c000001", "0x7fc96c000002"]}
+{"address":"0x7fc96c000001", "type":"OBJECT", "references":["0x7fc96c000002"], "file":"app.rb", "line":1}
+{"address":"0x7fc96c000002", "type":"OBJECT", "generation":3}
+{"address":"0x7fc96c000003", "type":"OBJECT"}
+`))
+	report := rubyobj.NewStatsReport()
+	for i := range objs {
+		report.Add(&objs[i])
+	}
+	stats := report.Stats()
+
+	if stats.Objects != 3 || stats.References != 1 {
+		t.Errorf("want 3 objects and 1 reference, got %d and %d", stats.Objects, stats.References)
+	}
+	if len(stats.Roots) != 1 || stats.Roots[0] != (rubyobj.RootStat{Category: "vm", References: 2}) {
+		t.Errorf("want 2 references from vm roots, got %+v", stats.Roots)
+	}
+	// The references of the ROOT record aren't counted.
+	if got := stats.InDegreePercentiles; got.Max != 1 || got.Min != 0 {
+		t.Errorf("want in-degrees of 0 to 1, got %+v", got)
+	}
+	// An object allocated at generation 0 is still traced if it has a file.
+	if stats.WithoutGenerations != 1 || stats.Generations.Min != 0 || stats.Generations.Max != 3 {
+		t.Errorf("want 2 traced objects of generations 0 to 3, got %d untraced and %+v", stats.WithoutGenerations, stats.Generations)
+	}
+}
+
+func TestStatsAgreeWithAgeReport(t *testing.T) {
+	f, err := os.Open("testdata/small.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	h, err := rubyobj.LoadHeap(f, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := rubyobj.NewStatsReport()
+	objs := h.Objects()
+	for i := range objs {
+		report.Add(&objs[i])
+	}
+	stats := report.Stats()
+	age := rubyobj.NewAgeReport(h, 1)
+	if stats.WithoutGenerations != age.Untraced {
+		t.Errorf("stats count %d untraced objects, the age report %d", stats.WithoutGenerations, age.Untraced)
+	}
+
+	var maxReferrers uint64
+	for i := range objs {
+		if n := uint64(len(h.Referrers(objs[i].Address))); n > maxReferrers {
+			maxReferrers = n
+		}
+	}
+	if stats.InDegreePercentiles.Max != maxReferrers {
+		t.Errorf("want a max in-degree of %d like Heap.Referrers, got %d", maxReferrers, stats.InDegreePercentiles.Max)
+	}
+}
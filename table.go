@@ -0,0 +1,207 @@
+package rubyobj
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// column is a field of RubyObjects exported in tabular form.  Columns are
+// named after the members of the ObjectSpace dump.
+type column struct {
+	name  string
+	typ   string // one of string, long or boolean
+	value func(rObj *RubyObject) string
+}
+
+func formatOptAddress(addr uint64) string {
+	if addr == 0 {
+		return ""
+	}
+	return FormatAddress(addr)
+}
+
+func formatValue(rObj *RubyObject) string {
+	if rObj.Value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", rObj.Value)
+}
+
+func uintColumn(name string, field func(*RubyObject) uint64) column {
+	return column{name, "long", func(o *RubyObject) string { return strconv.FormatUint(field(o), 10) }}
+}
+
+func boolColumn(name string, field func(RubyObject) bool) column {
+	return column{name, "boolean", func(o *RubyObject) string { return strconv.FormatBool(field(*o)) }}
+}
+
+var objectColumns = []column{
+	{"address", "string", func(o *RubyObject) string { return formatOptAddress(o.Address) }},
+	{"type", "string", func(o *RubyObject) string { return o.Type.Name() }},
+	{"root", "string", func(o *RubyObject) string { return o.Root }},
+	{"class", "string", func(o *RubyObject) string { return formatOptAddress(o.Class) }},
+	{"node_type", "string", func(o *RubyObject) string { return o.NodeType }},
+	{"value", "string", formatValue},
+	{"name", "string", func(o *RubyObject) string { return o.Name }},
+	{"default", "string", func(o *RubyObject) string { return formatOptAddress(o.Default) }},
+	uintColumn("generation", func(o *RubyObject) uint64 { return o.Generation }),
+	uintColumn("bytesize", func(o *RubyObject) uint64 { return o.Bytesize }),
+	{"fd", "long", func(o *RubyObject) string { return strconv.Itoa(o.Fd) }},
+	{"file", "string", func(o *RubyObject) string { return o.File }},
+	uintColumn("line", func(o *RubyObject) uint64 { return o.Line }),
+	{"method", "string", func(o *RubyObject) string { return o.Method }},
+	{"encoding", "string", func(o *RubyObject) string { return o.Encoding }},
+	uintColumn("ivars", func(o *RubyObject) uint64 { return o.Ivars }),
+	uintColumn("length", func(o *RubyObject) uint64 { return o.Length }),
+	uintColumn("memsize", func(o *RubyObject) uint64 { return o.Memsize }),
+	uintColumn("capacity", func(o *RubyObject) uint64 { return o.Capacity }),
+	uintColumn("size", func(o *RubyObject) uint64 { return o.Size }),
+	{"struct", "string", func(o *RubyObject) string { return o.Struct }},
+	boolColumn("frozen", RubyObject.Frozen),
+	boolColumn("embedded", RubyObject.Embedded),
+	boolColumn("broken", RubyObject.Broken),
+	boolColumn("fstring", RubyObject.Fstring),
+	boolColumn("shared", RubyObject.Shared),
+	boolColumn("wb_protected", RubyObject.GcWbProtected),
+	boolColumn("old", RubyObject.GcOld),
+	boolColumn("marked", RubyObject.GcMarked),
+}
+
+// typedValue is the value of col for rObj as an int64, a bool or a string,
+// for the formats with typed columns.  Their integers are signed, so
+// unsigned values above math.MaxInt64 are stored as the int64 of the same
+// bits, negative; cast them back to uint64 when reading.
+func typedValue(col column, rObj *RubyObject) interface{} {
+	v := col.value(rObj)
+	switch col.typ {
+	case "boolean":
+		return v == "true"
+	case "long":
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i
+		}
+		u, _ := strconv.ParseUint(v, 10, 64)
+		return int64(u)
+	default:
+		return v
+	}
+}
+
+// ObjectColumns are the names of the columns that can be exported by a
+// TableWriter, in their default order.
+func ObjectColumns() []string {
+	names := make([]string, len(objectColumns))
+	for i, col := range objectColumns {
+		names[i] = col.name
+	}
+	return names
+}
+
+// selectColumns finds the columns with the given names, or all of them if
+// names is empty.
+func selectColumns(names []string) ([]column, error) {
+	if len(names) == 0 {
+		return objectColumns, nil
+	}
+	cols := make([]column, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, col := range objectColumns {
+			if col.name == name {
+				cols = append(cols, col)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+	return cols, nil
+}
+
+// TableWriter writes RubyObjects as two CSV tables: one row per object in the
+// objects table, and one row per reference in the edges table.  The edges
+// table has a from, a to and an ordinal column, the latter being the position
+// of the reference in the referencing object.  References held by ROOT records
+// have an empty from.
+type TableWriter struct {
+	cols    []column
+	objects *csv.Writer
+	edges   *csv.Writer
+
+	row     []string
+	started bool
+}
+
+// NewTableWriter creates a writer of the objects and edges tables, using
+// comma as the field separator.  Only the given columns are written, all of
+// them if there are none.
+func NewTableWriter(objects, edges io.Writer, comma rune, columns []string) (*TableWriter, error) {
+	cols, err := selectColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+	t := &TableWriter{
+		cols:    cols,
+		objects: csv.NewWriter(objects),
+		edges:   csv.NewWriter(edges),
+		row:     make([]string, len(cols)),
+	}
+	t.objects.Comma = comma
+	t.edges.Comma = comma
+	return t, nil
+}
+
+func (t *TableWriter) writeHeaders() error {
+	t.started = true
+	for i, col := range t.cols {
+		t.row[i] = col.name
+	}
+	if err := t.objects.Write(t.row); err != nil {
+		return err
+	}
+	return t.edges.Write([]string{"from", "to", "ordinal"})
+}
+
+// Write adds a row for rObj to the objects table, and a row for each of its
+// references to the edges table.
+func (t *TableWriter) Write(rObj *RubyObject) error {
+	if !t.started {
+		if err := t.writeHeaders(); err != nil {
+			return err
+		}
+	}
+	for i, col := range t.cols {
+		t.row[i] = col.value(rObj)
+	}
+	if err := t.objects.Write(t.row); err != nil {
+		return err
+	}
+
+	from := formatOptAddress(rObj.Address)
+	for i, to := range rObj.References {
+		err := t.edges.Write([]string{from, FormatAddress(to), strconv.Itoa(i)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered data to the underlying writers.
+func (t *TableWriter) Flush() error {
+	if !t.started {
+		if err := t.writeHeaders(); err != nil {
+			return err
+		}
+	}
+	t.objects.Flush()
+	if err := t.objects.Error(); err != nil {
+		return err
+	}
+	t.edges.Flush()
+	return t.edges.Error()
+}
@@ -0,0 +1,40 @@
+package rubyobj
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTypedValue(t *testing.T) {
+	col := func(name string) column {
+		for _, col := range objectColumns {
+			if col.name == name {
+				return col
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return column{}
+	}
+	rObj := &RubyObject{Type: String, Memsize: math.MaxUint64, Bytesize: math.MaxInt64, Fd: -1, flags: frozen}
+
+	tests := []struct {
+		col  string
+		want interface{}
+	}{
+		// Above math.MaxInt64, the bits are kept.
+		{"memsize", int64(-1)},
+		{"bytesize", int64(math.MaxInt64)},
+		{"fd", int64(-1)},
+		{"frozen", true},
+		{"embedded", false},
+		{"type", "STRING"},
+	}
+	for _, tt := range tests {
+		if got := typedValue(col(tt.col), rObj); got != tt.want {
+			t.Errorf("%s: want %#v, got %#v", tt.col, tt.want, got)
+		}
+	}
+	if got := uint64(typedValue(col("memsize"), rObj).(int64)); got != rObj.Memsize {
+		t.Errorf("want %d back, got %d", rObj.Memsize, got)
+	}
+}
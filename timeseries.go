@@ -0,0 +1,213 @@
+package rubyobj
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Series is the number and memsize of a group of objects, in each dump of a
+// TimeSeries.
+type Series struct {
+	// Key is the name of a class or an allocation site.
+	Key      string   `json:"key"`
+	Counts   []uint64 `json:"counts"`
+	Memsizes []uint64 `json:"memsizes"`
+	// LeakCandidate is set if the group never shrank and grew overall, over
+	// at least 3 dumps.
+	LeakCandidate bool `json:"leak_candidate"`
+}
+
+func growing(values []uint64) bool {
+	if len(values) < 3 || values[len(values)-1] <= values[0] {
+		return false
+	}
+	for i := 1; i < len(values); i++ {
+		if values[i] < values[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// TimeSeries follows classes and allocation sites across a sequence of
+// dumps, like dumps taken every hour from the same process.
+type TimeSeries struct {
+	// Dumps label the dumps, in the order they were added.
+	Dumps []string `json:"dumps"`
+	// Classes and Sites are sorted by memsize in the last dump, biggest
+	// first.
+	Classes []*Series `json:"classes"`
+	Sites   []*Series `json:"sites"`
+
+	classes map[string]*Series
+	sites   map[string]*Series
+}
+
+// NewTimeSeries creates a time series of no dumps.
+func NewTimeSeries() *TimeSeries {
+	return &TimeSeries{
+		classes: make(map[string]*Series),
+		sites:   make(map[string]*Series),
+	}
+}
+
+// AddDump decodes the dump in r with para goroutines, adding it to the
+// series after the dumps added before, under label.  Objects whose
+// allocation wasn't traced aren't in the sites.  Like LoadHeap, the dump is
+// added even if some lines can't be decoded, and the error says how many.
+func (t *TimeSeries) AddDump(label string, r io.Reader, para uint) error {
+	type stat struct{ count, memsize uint64 }
+	byClass := make(map[uint64]*stat)
+	classless := make(map[RubyType]*stat)
+	bySite := make(map[AllocSite]*stat)
+	classes := NewClassIndex()
+
+	add := func(s *stat, rObj *RubyObject) {
+		s.count++
+		s.memsize += rObj.Memsize
+	}
+
+	objC, errC := ParallelDecode(r, para)
+	var errs decodeErrors
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for err := range errC {
+			if errs.first == nil {
+				errs.first = err
+			}
+			errs.count++
+		}
+	}()
+	for rObj := range objC {
+		if rObj.Type == Root {
+			continue
+		}
+		classes.Add(&rObj)
+
+		var s *stat
+		var ok bool
+		if rObj.Class == 0 {
+			if s, ok = classless[rObj.Type]; !ok {
+				s = new(stat)
+				classless[rObj.Type] = s
+			}
+		} else if s, ok = byClass[rObj.Class]; !ok {
+			s = new(stat)
+			byClass[rObj.Class] = s
+		}
+		add(s, &rObj)
+
+		if rObj.File == "" {
+			continue
+		}
+		site := AllocSite{File: rObj.File, Line: rObj.Line}
+		if s, ok = bySite[site]; !ok {
+			s = new(stat)
+			bySite[site] = s
+		}
+		add(s, &rObj)
+	}
+	<-done
+
+	// Classes are only known by name from one dump to the next, as their
+	// address changes.  Anonymous classes are all counted together.
+	n := len(t.Dumps)
+	t.Dumps = append(t.Dumps, label)
+	for class, s := range byClass {
+		name, ok := classes.classes[class]
+		if !ok {
+			name = "(anonymous)"
+		}
+		t.point(t.classes, name, n, s.count, s.memsize)
+	}
+	for typ, s := range classless {
+		t.point(t.classes, "("+typ.Name()+")", n, s.count, s.memsize)
+	}
+	for site, s := range bySite {
+		t.point(t.sites, site.String(), n, s.count, s.memsize)
+	}
+	t.update()
+
+	if errs.count != 0 {
+		return fmt.Errorf("dump %s: %v", label, &errs)
+	}
+	return nil
+}
+
+// WriteCSV writes the series as CSV, one row per series and dump, with the
+// columns group (class or site), key, dump, count, memsize and
+// leak_candidate.
+func (t *TimeSeries) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"group", "key", "dump", "count", "memsize", "leak_candidate"})
+	for _, group := range []struct {
+		name   string
+		series []*Series
+	}{{"class", t.Classes}, {"site", t.Sites}} {
+		for _, s := range group.series {
+			for i, dump := range t.Dumps {
+				cw.Write([]string{
+					group.name,
+					s.Key,
+					dump,
+					strconv.FormatUint(s.Counts[i], 10),
+					strconv.FormatUint(s.Memsizes[i], 10),
+					strconv.FormatBool(s.LeakCandidate),
+				})
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// point adds to the value of the series of key in dump n.
+func (t *TimeSeries) point(series map[string]*Series, key string, n int, count, memsize uint64) {
+	s, ok := series[key]
+	if !ok {
+		s = &Series{Key: key}
+		series[key] = s
+	}
+	for len(s.Counts) <= n {
+		s.Counts = append(s.Counts, 0)
+		s.Memsizes = append(s.Memsizes, 0)
+	}
+	s.Counts[n] += count
+	s.Memsizes[n] += memsize
+}
+
+// update pads the series that were missing from the last dump, flags leak
+// candidates and sorts the series.
+func (t *TimeSeries) update() {
+	list := func(series map[string]*Series) []*Series {
+		all := make([]*Series, 0, len(series))
+		for _, s := range series {
+			for len(s.Counts) < len(t.Dumps) {
+				s.Counts = append(s.Counts, 0)
+				s.Memsizes = append(s.Memsizes, 0)
+			}
+			s.LeakCandidate = growing(s.Counts) || growing(s.Memsizes)
+			all = append(all, s)
+		}
+		sort.Sort(seriesByLastMemsize(all))
+		return all
+	}
+	t.Classes = list(t.classes)
+	t.Sites = list(t.sites)
+}
+
+type seriesByLastMemsize []*Series
+
+func (s seriesByLastMemsize) Len() int      { return len(s) }
+func (s seriesByLastMemsize) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s seriesByLastMemsize) Less(i, j int) bool {
+	last := len(s[i].Memsizes) - 1
+	if s[i].Memsizes[last] != s[j].Memsizes[last] {
+		return s[i].Memsizes[last] > s[j].Memsizes[last]
+	}
+	return s[i].Key < s[j].Key
+}
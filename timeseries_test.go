@@ -0,0 +1,180 @@
+package rubyobj_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"github.com/aybabtme/rubyobj"
+	"strings"
+	"testing"
+)
+
+// seriesSpec is a class with counts instances of memsizes bytes in each of
+// 4 dumps.
+type seriesSpec struct {
+	name     string
+	file     string
+	counts   [4]int
+	memsizes [4]uint64
+	leak     bool
+}
+
+var seriesSpecs = []seriesSpec{
+	{"Leaky", "leaky.rb", [4]int{1, 2, 2, 5}, [4]uint64{10, 10, 10, 10}, true},
+	{"Flat", "flat.rb", [4]int{2, 2, 2, 2}, [4]uint64{10, 10, 10, 10}, false},
+	{"Bouncy", "", [4]int{1, 3, 2, 4}, [4]uint64{10, 10, 10, 10}, false},
+	{"Fattening", "", [4]int{1, 1, 1, 1}, [4]uint64{10, 20, 30, 40}, true},
+	{"Late", "", [4]int{0, 0, 1, 2}, [4]uint64{10, 10, 10, 10}, true},
+	{"Gone", "", [4]int{2, 2, 0, 0}, [4]uint64{10, 10, 10, 10}, false},
+}
+
+// seriesDump is the n-th dump of seriesSpecs.  Classes are at other
+// addresses in each dump, like in dumps of a running process.
+func seriesDump(n int) string {
+	buf := bytes.NewBuffer(nil)
+	for i, spec := range seriesSpecs {
+		if spec.counts[n] == 0 {
+			continue
+		}
+		class := 0x7fc96c000000 + uint64(n)<<16 + uint64(i+1)<<8
+		fmt.Fprintf(buf, `{"address":"%s", "type":"CLASS", "name":"%s"}`+"\n", rubyobj.FormatAddress(class), spec.name)
+		for j := 0; j < spec.counts[n]; j++ {
+			site := ""
+			if spec.file != "" {
+				site = fmt.Sprintf(`, "file":"%s", "line":%d`, spec.file, i+1)
+			}
+			fmt.Fprintf(buf, `{"address":"%s", "type":"OBJECT", "class":"%s", "memsize":%d%s}`+"\n",
+				rubyobj.FormatAddress(class+uint64(j+1)), rubyobj.FormatAddress(class), spec.memsizes[n], site)
+		}
+	}
+	// Strings without a class, going down, and instances of a class
+	// that isn't in the dump, going up.
+	for j := 0; j < 3-n; j++ {
+		fmt.Fprintf(buf, `{"address":"%s", "type":"STRING", "memsize":40}`+"\n", rubyobj.FormatAddress(0x7fc96c100000+uint64(n)<<16+uint64(j)))
+	}
+	for j := 0; j <= n; j++ {
+		fmt.Fprintf(buf, `{"address":"%s", "type":"OBJECT", "class":"0x7fc96cf00000", "memsize":1}`+"\n", rubyobj.FormatAddress(0x7fc96c200000+uint64(n)<<16+uint64(j)))
+	}
+	return buf.String()
+}
+
+func findSeries(series []*rubyobj.Series, key string) *rubyobj.Series {
+	for _, s := range series {
+		if s.Key == key {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestTimeSeriesLeaks(t *testing.T) {
+	ts := rubyobj.NewTimeSeries()
+	for n := 0; n < 4; n++ {
+		if err := ts.AddDump(fmt.Sprintf("dump%d", n), strings.NewReader(seriesDump(n)), 2); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, spec := range seriesSpecs {
+			// Classes get a series with their first instance.
+			var seen int
+			for i := 0; i <= n; i++ {
+				seen += spec.counts[i]
+			}
+			s := findSeries(ts.Classes, spec.name)
+			if seen == 0 {
+				if s != nil {
+					t.Errorf("dump %d: want no series for %s yet, got %+v", n, spec.name, s)
+				}
+				continue
+			}
+			if s == nil {
+				t.Fatalf("dump %d: no series for %s", n, spec.name)
+			}
+			if len(s.Counts) != n+1 || len(s.Memsizes) != n+1 {
+				t.Fatalf("dump %d: want %d points for %s, got %+v", n, n+1, spec.name, s)
+			}
+			for i := 0; i <= n; i++ {
+				if s.Counts[i] != uint64(spec.counts[i]) || s.Memsizes[i] != uint64(spec.counts[i])*spec.memsizes[i] {
+					t.Fatalf("dump %d: want %v and %v for %s, got %+v", n, spec.counts, spec.memsizes, spec.name, s)
+				}
+			}
+			// Growing over 1 or 2 dumps isn't enough.
+			want := spec.leak && n >= 2
+			if s.LeakCandidate != want {
+				t.Errorf("dump %d: want %s a leak candidate: %v", n, spec.name, want)
+			}
+		}
+	}
+	if len(ts.Dumps) != 4 || ts.Dumps[3] != "dump3" {
+		t.Errorf("want 4 dumps, got %v", ts.Dumps)
+	}
+
+	strs := findSeries(ts.Classes, "(STRING)")
+	if strs == nil || strs.LeakCandidate || strs.Counts[0] != 3 || strs.Counts[3] != 0 {
+		t.Errorf("want strings going from 3 to 0, got %+v", strs)
+	}
+	anon := findSeries(ts.Classes, "(anonymous)")
+	if anon == nil || !anon.LeakCandidate || anon.Counts[3] != 4 {
+		t.Errorf("want a growing anonymous class, got %+v", anon)
+	}
+
+	if len(ts.Sites) != 2 {
+		t.Fatalf("want the 2 traced sites, got %+v", ts.Sites)
+	}
+	if s := findSeries(ts.Sites, "leaky.rb:1"); s == nil || !s.LeakCandidate {
+		t.Errorf("want leaky.rb:1 a leak candidate, got %+v", s)
+	}
+	if s := findSeries(ts.Sites, "flat.rb:2"); s == nil || s.LeakCandidate {
+		t.Errorf("want flat.rb:2 not a leak candidate, got %+v", s)
+	}
+
+	// Biggest in the last dump first.
+	for i := 1; i < len(ts.Classes); i++ {
+		if ts.Classes[i-1].Memsizes[3] < ts.Classes[i].Memsizes[3] {
+			t.Errorf("classes aren't sorted by memsize: %s before %s", ts.Classes[i-1].Key, ts.Classes[i].Key)
+		}
+	}
+	if ts.Classes[0].Key != "Leaky" {
+		t.Errorf("want Leaky first, got %s", ts.Classes[0].Key)
+	}
+}
+
+func TestTimeSeriesCSV(t *testing.T) {
+	ts := rubyobj.NewTimeSeries()
+	for n := 0; n < 3; n++ {
+		if err := ts.AddDump(fmt.Sprintf("dump%d", n), strings.NewReader(seriesDump(n)), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := bytes.NewBuffer(nil)
+	if err := ts.WriteCSV(out); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 1 + 3*(len(ts.Classes)+len(ts.Sites)); len(rows) != want {
+		t.Fatalf("want %d rows, got %d", want, len(rows))
+	}
+	found := false
+	for _, row := range rows {
+		if strings.Join(row, ",") == "site,leaky.rb:1,dump2,2,20,true" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("want the last point of leaky.rb:1, got %q", rows)
+	}
+}
+
+func TestTimeSeriesBadLines(t *testing.T) {
+	ts := rubyobj.NewTimeSeries()
+	err := ts.AddDump("bad", strings.NewReader(seriesDump(0)+"nope\n"), 1)
+	if err == nil || !strings.Contains(err.Error(), "dump bad") {
+		t.Errorf("want an error about dump bad, got %v", err)
+	}
+	if len(ts.Dumps) != 1 || findSeries(ts.Classes, "Leaky") == nil {
+		t.Errorf("the dump wasn't added")
+	}
+}
@@ -0,0 +1,206 @@
+package rubyobj
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// IssueKind is a kind of problem found by Validate.
+type IssueKind string
+
+// The kinds of issues, in the order Validate reports them.
+const (
+	IssueUndecodable       IssueKind = "undecodable line"
+	IssueTruncated         IssueKind = "truncated final line"
+	IssueDuplicateAddress  IssueKind = "duplicate address"
+	IssueDanglingReference IssueKind = "dangling reference"
+	IssueMissingClass      IssueKind = "missing class"
+	IssueNoRoots           IssueKind = "no ROOT records"
+	IssueInvariant         IssueKind = "broken invariant"
+)
+
+// IssueKinds are all the kinds of issues, in the order Validate reports them.
+var IssueKinds = []IssueKind{
+	IssueUndecodable,
+	IssueTruncated,
+	IssueDuplicateAddress,
+	IssueDanglingReference,
+	IssueMissingClass,
+	IssueNoRoots,
+	IssueInvariant,
+}
+
+// Issue is a problem with a dump.  Line counts from 1, and is 0 for issues
+// of the whole dump.
+type Issue struct {
+	Kind    IssueKind
+	Line    int
+	Address uint64
+	Message string
+}
+
+func (i Issue) String() string {
+	if i.Line == 0 {
+		return i.Message
+	}
+	return fmt.Sprintf("line %d: %s", i.Line, i.Message)
+}
+
+// ValidationReport counts the issues of a dump, keeping a sample of each
+// kind.
+type ValidationReport struct {
+	Objects int
+	Roots   int
+	Counts  map[IssueKind]int
+	Samples map[IssueKind][]Issue
+
+	maxSamples int
+}
+
+// Valid tells if no issue was found.
+func (v *ValidationReport) Valid() bool {
+	return len(v.Counts) == 0
+}
+
+func (v *ValidationReport) add(issue Issue) {
+	v.Counts[issue.Kind]++
+	if len(v.Samples[issue.Kind]) < v.maxSamples {
+		v.Samples[issue.Kind] = append(v.Samples[issue.Kind], issue)
+	}
+}
+
+// Validate decodes the dump in r with para goroutines and checks that it's
+// consistent: every line decodes, the last one isn't cut short, addresses
+// are unique, references and classes point to objects of the dump, there are
+// ROOT records, and objects hold together.  The report keeps up to samples
+// issues of each kind.  The error is only about reading r; whatever is wrong
+// with the dump is in the report.
+func Validate(r io.Reader, para uint, samples int) (*ValidationReport, error) {
+	report := &ValidationReport{
+		Counts:     make(map[IssueKind]int),
+		Samples:    make(map[IssueKind][]Issue),
+		maxSamples: samples,
+	}
+
+	type object struct {
+		line  int
+		addr  uint64
+		class uint64
+		refs  []uint64
+	}
+	var objects []object
+	lines := make(map[uint64]int)
+
+	tail := &tailReader{r: r}
+	var lastErr *lineError
+	err := decodeRecords(tail, para, func(rec *Record) error {
+		rObj := &rec.RubyObject
+		if rObj.Type == Root {
+			report.Roots++
+			if rObj.Root == "" {
+				report.add(Issue{IssueInvariant, rec.line, 0, "ROOT record without a category"})
+			}
+			objects = append(objects, object{line: rec.line, refs: rObj.References})
+			return nil
+		}
+
+		report.Objects++
+		if rObj.Address == 0 {
+			report.add(Issue{IssueInvariant, rec.line, 0, fmt.Sprintf("%v object without an address", rObj.Type)})
+		} else if first, ok := lines[rObj.Address]; ok {
+			report.add(Issue{IssueDuplicateAddress, rec.line, rObj.Address,
+				fmt.Sprintf("%s was already at line %d", FormatAddress(rObj.Address), first)})
+		} else {
+			lines[rObj.Address] = rec.line
+		}
+		for _, msg := range invariants(rObj) {
+			report.add(Issue{IssueInvariant, rec.line, rObj.Address, FormatAddress(rObj.Address) + ": " + msg})
+		}
+		objects = append(objects, object{rec.line, rObj.Address, rObj.Class, rObj.References})
+		return nil
+	}, func(err error) {
+		lerr := err.(*lineError)
+		if lastErr != nil {
+			report.add(Issue{IssueUndecodable, lastErr.line, 0, lastErr.err.Error()})
+		}
+		lastErr = lerr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The last line is truncated if it didn't decode, which we can only tell
+	// once all the lines are in.
+	switch {
+	case lastErr != nil && lastErr.line == tail.lines() && tail.truncated():
+		report.add(Issue{IssueTruncated, lastErr.line, 0, "last line has no newline and doesn't decode: " + lastErr.err.Error()})
+	case lastErr != nil:
+		report.add(Issue{IssueUndecodable, lastErr.line, 0, lastErr.err.Error()})
+	case tail.truncated():
+		report.add(Issue{IssueTruncated, tail.lines(), 0, "last line has no newline, the dump may have been cut short"})
+	}
+
+	for _, obj := range objects {
+		if obj.class != 0 {
+			if _, ok := lines[obj.class]; !ok {
+				report.add(Issue{IssueMissingClass, obj.line, obj.addr,
+					fmt.Sprintf("class %s of %s isn't in the dump", FormatAddress(obj.class), FormatAddress(obj.addr))})
+			}
+		}
+		for _, ref := range obj.refs {
+			if _, ok := lines[ref]; !ok {
+				from := "ROOT"
+				if obj.addr != 0 {
+					from = FormatAddress(obj.addr)
+				}
+				report.add(Issue{IssueDanglingReference, obj.line, obj.addr,
+					fmt.Sprintf("%s references %s, which isn't in the dump", from, FormatAddress(ref))})
+			}
+		}
+	}
+	if report.Roots == 0 && report.Objects != 0 {
+		report.add(Issue{IssueNoRoots, 0, 0, "the dump has no ROOT records, it was likely made without all: true or cut short"})
+	}
+	return report, nil
+}
+
+// invariants checks what must hold for objects of some types.  How much
+// Ruby embeds in a slot depends on its version and the size of the slot, so
+// embedded objects aren't checked.
+func invariants(rObj *RubyObject) []string {
+	var broken []string
+	if rObj.Type == String && rObj.Capacity != 0 && rObj.Bytesize > rObj.Capacity {
+		broken = append(broken, fmt.Sprintf("string of %d bytes with a capacity of %d", rObj.Bytesize, rObj.Capacity))
+	}
+	return broken
+}
+
+// tailReader remembers how many lines it read and if the last one ended with
+// a newline.
+type tailReader struct {
+	r        io.Reader
+	newlines int
+	last     byte
+}
+
+func (t *tailReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n != 0 {
+		t.newlines += bytes.Count(p[:n], []byte{'\n'})
+		t.last = p[n-1]
+	}
+	return n, err
+}
+
+// lines counts the last line even if it has no newline.
+func (t *tailReader) lines() int {
+	if t.last == 0 || t.last == '\n' {
+		return t.newlines
+	}
+	return t.newlines + 1
+}
+
+func (t *tailReader) truncated() bool {
+	return t.last != 0 && t.last != '\n'
+}
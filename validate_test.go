@@ -0,0 +1,139 @@
+package rubyobj_test
+
+import (
+	"github.com/aybabtme/rubyobj"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	const root = `{"type":"ROOT", "root":"vm", "references":["0x7fc96c000001"]}` + "\n"
+	const obj = `{"address":"0x7fc96c000001", "type":"OBJECT"}` + "\n"
+
+	type issue struct {
+		kind rubyobj.IssueKind
+		line int
+		addr uint64
+	}
+	tests := []struct {
+		name string
+		dump string
+		want []issue
+	}{
+		{"valid", root + obj, nil},
+		{"empty", "", nil},
+		{"blank lines", "\n" + root + "\n" + obj + "\n", nil},
+
+		{"undecodable", root + `{"address":` + "\n" + obj, []issue{
+			{rubyobj.IssueUndecodable, 2, 0},
+		}},
+		{"undecodable last line", root + obj + `{"address":` + "\n", []issue{
+			{rubyobj.IssueUndecodable, 3, 0},
+		}},
+		{"undecodable lines", root + "nope\n" + obj + "nope\n", []issue{
+			{rubyobj.IssueUndecodable, 2, 0},
+			{rubyobj.IssueUndecodable, 4, 0},
+		}},
+		{"truncated", root + obj + `{"address":"0x7fc96c000002", "ty`, []issue{
+			{rubyobj.IssueTruncated, 3, 0},
+		}},
+		{"no final newline", root + strings.TrimSuffix(obj, "\n"), []issue{
+			{rubyobj.IssueTruncated, 2, 0},
+		}},
+
+		{"duplicate address", root + obj + `{"address":"0x7fc96c000001", "type":"STRING"}` + "\n", []issue{
+			{rubyobj.IssueDuplicateAddress, 3, 0x7fc96c000001},
+		}},
+		{"dangling reference", root + `{"address":"0x7fc96c000001", "type":"OBJECT", "references":["0x7fc96c000001", "0x7fc96c000009"]}` + "\n", []issue{
+			{rubyobj.IssueDanglingReference, 2, 0x7fc96c000001},
+		}},
+		{"dangling root reference", `{"type":"ROOT", "root":"vm", "references":["0x7fc96c000009"]}` + "\n" + obj, []issue{
+			{rubyobj.IssueDanglingReference, 1, 0},
+		}},
+		{"missing class", root + `{"address":"0x7fc96c000001", "type":"OBJECT", "class":"0x7fc96c000100"}` + "\n", []issue{
+			{rubyobj.IssueMissingClass, 2, 0x7fc96c000001},
+		}},
+		{"class", root + `{"address":"0x7fc96c000001", "type":"OBJECT", "class":"0x7fc96c000100"}` + "\n" +
+			`{"address":"0x7fc96c000100", "type":"CLASS", "name":"Foo"}` + "\n", nil},
+		{"no roots", obj, []issue{
+			{rubyobj.IssueNoRoots, 0, 0},
+		}},
+
+		{"root without category", `{"type":"ROOT", "references":["0x7fc96c000001"]}` + "\n" + obj, []issue{
+			{rubyobj.IssueInvariant, 1, 0},
+		}},
+		{"object without address", root + obj + `{"type":"OBJECT"}` + "\n", []issue{
+			{rubyobj.IssueInvariant, 3, 0},
+		}},
+		{"long string", root + `{"address":"0x7fc96c000001", "type":"STRING", "bytesize":1000}` + "\n", nil},
+		{"string over capacity", root + `{"address":"0x7fc96c000001", "type":"STRING", "bytesize":30, "capacity":29}` + "\n", []issue{
+			{rubyobj.IssueInvariant, 2, 0x7fc96c000001},
+		}},
+	}
+	for _, tt := range tests {
+		report, err := rubyobj.Validate(strings.NewReader(tt.dump), 2, 10)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []issue
+		for _, kind := range rubyobj.IssueKinds {
+			for _, s := range report.Samples[kind] {
+				got = append(got, issue{s.Kind, s.Line, s.Address})
+			}
+			if report.Counts[kind] != len(report.Samples[kind]) {
+				t.Errorf("%s: counted %d %s, kept %d", tt.name, report.Counts[kind], kind, len(report.Samples[kind]))
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: want %+v, got %+v", tt.name, tt.want, got)
+		}
+		if report.Valid() != (len(tt.want) == 0) {
+			t.Errorf("%s: want valid %v", tt.name, len(tt.want) == 0)
+		}
+	}
+}
+
+func TestValidateSamples(t *testing.T) {
+	report, err := rubyobj.Validate(strings.NewReader(`{"type":"ROOT", "root":"vm", "references":["0x7fc96c000001", "0x7fc96c000008"]}
+{"address":"0x7fc96c000001", "type":"OBJECT", "references":["0x7fc96c000009", "0x7fc96c00000a"]}
+`), 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Objects != 1 || report.Roots != 1 {
+		t.Errorf("want 1 object and 1 root, got %d and %d", report.Objects, report.Roots)
+	}
+	if n := report.Counts[rubyobj.IssueDanglingReference]; n != 3 {
+		t.Errorf("want 3 dangling references, got %d", n)
+	}
+	samples := report.Samples[rubyobj.IssueDanglingReference]
+	if len(samples) != 2 {
+		t.Fatalf("want 2 samples, got %+v", samples)
+	}
+	if want := "line 1: ROOT references 0x7fc96c000008, which isn't in the dump"; samples[0].String() != want {
+		t.Errorf("want %q, got %q", want, samples[0].String())
+	}
+}
+
+func TestValidateSmallDump(t *testing.T) {
+	f, err := os.Open("testdata/small.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	report, err := rubyobj.Validate(f, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Objects == 0 || report.Roots == 0 {
+		t.Fatalf("want objects and roots, got %d and %d", report.Objects, report.Roots)
+	}
+	for _, kind := range []rubyobj.IssueKind{rubyobj.IssueUndecodable, rubyobj.IssueTruncated, rubyobj.IssueDuplicateAddress, rubyobj.IssueNoRoots} {
+		if n := report.Counts[kind]; n != 0 {
+			t.Errorf("found %d %s in small.json: %v", n, kind, report.Samples[kind])
+		}
+	}
+}